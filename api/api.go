@@ -1,8 +1,11 @@
 package api
 
 import (
+	"animuxd/irc"
 	"animuxd/xdcc"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -10,13 +13,27 @@ import (
 )
 
 type requestFilePayload struct {
+	Network       string
 	BotNick       string
 	PackageNumber int
 	FileName      string
 }
 
+// StatusReporter reports state of the daemon, e.g. its IRC connection.
+type StatusReporter interface {
+	StatusJSON(writer io.Writer) error
+}
+
 // NewRouter setups a http router for given instance of XDCCEngine.
-func NewRouter(engine xdcc.XDCCEngine) http.Handler {
+// Status endpoint is served only when status reporter is given.
+// Cross-origin requests are allowed.
+func NewRouter(engine xdcc.XDCCEngine, status StatusReporter) http.Handler {
+	return NewRouterWithUI(engine, status, UI{CORS: true})
+}
+
+// NewRouterWithUI setups a http router like NewRouter which also serves the web app
+// on all paths not taken by the API.
+func NewRouterWithUI(engine xdcc.XDCCEngine, status StatusReporter, ui UI) http.Handler {
 	router := httprouter.New()
 
 	createDownload := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -33,10 +50,23 @@ func NewRouter(engine xdcc.XDCCEngine) http.Handler {
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		requestPromise := engine.RequestFile(payload.BotNick, payload.PackageNumber, payload.FileName)
-		<-requestPromise
+		requestPromise := engine.RequestFile(payload.Network, payload.BotNick, payload.PackageNumber, payload.FileName)
+		err = <-requestPromise
+		if errors.Is(err, xdcc.ErrUnknownNetwork) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, xdcc.ErrFileNameTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 
+		w.Header().Set("Content-Type", "application/json")
+		// Downloads of offline bots are accepted, but requested only once the bots come back.
+		if errors.Is(err, irc.ErrNoSuchNick) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
 		w.WriteHeader(http.StatusCreated)
 	}
 
@@ -49,8 +79,28 @@ func NewRouter(engine xdcc.XDCCEngine) http.Handler {
 		}
 	}
 
+	showStatus := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		err := status.StatusJSON(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+	}
+
 	router.POST("/downloads", createDownload)
 	router.GET("/downloads", indexDownloads)
+	if status != nil {
+		router.GET("/status", showStatus)
+	}
+
+	if ui.Files != nil {
+		router.NotFound = uiHandler(ui.Files)
+	}
+
+	if !ui.CORS {
+		return router
+	}
 
 	handler := cors.Default().Handler(router)
 	return handler
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"animuxd/irc"
+	"animuxd/xdcc"
 	"fmt"
 	"io"
 	"net/http"
@@ -19,11 +21,23 @@ func (e *fakeXdccEngine) Start() {
 	e.Requested = make([]string, 0)
 }
 
-func (e *fakeXdccEngine) RequestFile(botNick string, packageNo int, fileName string) <-chan bool {
-	r := make(chan bool)
+func (e *fakeXdccEngine) RequestFile(networkName string, botNick string, packageNo int, fileName string) <-chan error {
+	r := make(chan error)
 	go func() {
-		e.Requested = append(e.Requested, fmt.Sprintf("%s|%d|%s", botNick, packageNo, fileName))
-		r <- true
+		if networkName == "unknown" {
+			r <- xdcc.ErrUnknownNetwork
+			return
+		}
+		if fileName == "taken.mkv" {
+			r <- xdcc.ErrFileNameTaken
+			return
+		}
+		e.Requested = append(e.Requested, fmt.Sprintf("%s|%s|%d|%s", networkName, botNick, packageNo, fileName))
+		if botNick == "offline" {
+			r <- irc.IRCError{Code: "401", Target: botNick}
+			return
+		}
+		r <- nil
 	}()
 
 	return r
@@ -35,23 +49,82 @@ func (e *fakeXdccEngine) DownloadsJSON(writer io.Writer) error {
 	return nil
 }
 
+type fakeStatusReporter struct{}
+
+func (s *fakeStatusReporter) StatusJSON(writer io.Writer) error {
+	writer.Write([]byte(`{"Connected":true}`))
+	return nil
+}
+
 func TestPostDownloads(t *testing.T) {
 	engine := &fakeXdccEngine{}
 	engine.Start()
-	router := NewRouter(engine)
+	router := NewRouter(engine, nil)
 
 	r, _ := http.NewRequest("POST", "/downloads", strings.NewReader(`{"fileName": "foo.mkv", "botNick": "bar", "packageNumber": 2137}`))
 	w := httptest.NewRecorder()
 
 	router.ServeHTTP(w, r)
-	assert.Equal(t, "bar|2137|foo.mkv", engine.Requested[0])
+	assert.Equal(t, "|bar|2137|foo.mkv", engine.Requested[0])
 	assert.Equal(t, http.StatusCreated, w.Result().StatusCode)
 }
 
+func TestPostDownloadsWithNetwork(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	router := NewRouter(engine, nil)
+
+	r, _ := http.NewRequest("POST", "/downloads", strings.NewReader(`{"network": "rizon", "fileName": "foo.mkv", "botNick": "bar", "packageNumber": 2137}`))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+	assert.Equal(t, "rizon|bar|2137|foo.mkv", engine.Requested[0])
+	assert.Equal(t, http.StatusCreated, w.Result().StatusCode)
+}
+
+func TestPostDownloadsUnknownNetwork(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	router := NewRouter(engine, nil)
+
+	r, _ := http.NewRequest("POST", "/downloads", strings.NewReader(`{"network": "unknown", "fileName": "foo.mkv", "botNick": "bar", "packageNumber": 2137}`))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+	assert.Len(t, engine.Requested, 0)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestPostDownloadsFileNameTaken(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	router := NewRouter(engine, nil)
+
+	r, _ := http.NewRequest("POST", "/downloads", strings.NewReader(`{"network": "rizon", "fileName": "taken.mkv", "botNick": "bar", "packageNumber": 2137}`))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+	assert.Len(t, engine.Requested, 0)
+	assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+}
+
+func TestPostDownloadsBotOffline(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	router := NewRouter(engine, nil)
+
+	r, _ := http.NewRequest("POST", "/downloads", strings.NewReader(`{"fileName": "foo.mkv", "botNick": "offline", "packageNumber": 2137}`))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+	assert.Equal(t, "|offline|2137|foo.mkv", engine.Requested[0])
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+}
+
 func TestPostDownloadsUncompletePayload(t *testing.T) {
 	engine := &fakeXdccEngine{}
 	engine.Start()
-	router := NewRouter(engine)
+	router := NewRouter(engine, nil)
 
 	r, _ := http.NewRequest("POST", "/downloads", strings.NewReader(`{"fileName": "foo.mkv", "botNick": "bar"}`))
 	w := httptest.NewRecorder()
@@ -64,7 +137,7 @@ func TestPostDownloadsUncompletePayload(t *testing.T) {
 func TestPostDownloadsNotJSON(t *testing.T) {
 	engine := &fakeXdccEngine{}
 	engine.Start()
-	router := NewRouter(engine)
+	router := NewRouter(engine, nil)
 
 	r, _ := http.NewRequest("POST", "/downloads", strings.NewReader("foo"))
 	w := httptest.NewRecorder()
@@ -77,7 +150,7 @@ func TestPostDownloadsNotJSON(t *testing.T) {
 func TestGetDownloads(t *testing.T) {
 	engine := &fakeXdccEngine{}
 	engine.Start()
-	router := NewRouter(engine)
+	router := NewRouter(engine, nil)
 
 	r, _ := http.NewRequest("GET", "/downloads", nil)
 	w := httptest.NewRecorder()
@@ -86,3 +159,28 @@ func TestGetDownloads(t *testing.T) {
 	assert.Equal(t, `[{"foo":"bar"}]`, w.Body.String())
 	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
 }
+
+func TestGetStatus(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	router := NewRouter(engine, &fakeStatusReporter{})
+
+	r, _ := http.NewRequest("GET", "/status", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+	assert.Equal(t, `{"Connected":true}`, w.Body.String())
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestGetStatusWithoutReporter(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	router := NewRouter(engine, nil)
+
+	r, _ := http.NewRequest("GET", "/status", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"animuxd/supervisor"
+	"animuxd/xdcc"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// NetworkStatus is a supervisor.Status of a named network.
+type NetworkStatus struct {
+	Name string
+	supervisor.Status
+}
+
+// Status is served under /status by the daemon.
+type Status struct {
+	Networks []NetworkStatus
+}
+
+// A Client talks to the API of a running daemon.
+type Client struct {
+	// BaseURL is e.g. http://127.0.0.1:1337.
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// ErrBotOffline is returned by RequestFile when the daemon accepted the download,
+// but waits with the request until the bot comes online.
+var ErrBotOffline = errors.New("Bot is offline, the download waits for it")
+
+// A RequestError is returned when the API rejects a request.
+type RequestError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *RequestError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Request rejected with %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("Request rejected with %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// RequestFile asks the daemon to download package of the bot. Empty network means the default one.
+// Returns ErrBotOffline when the bot is offline.
+func (c *Client) RequestFile(networkName string, botNick string, packageNo int, fileName string) error {
+	body, err := json.Marshal(requestFilePayload{
+		Network:       networkName,
+		BotNick:       botNick,
+		PackageNumber: packageNo,
+		FileName:      fileName,
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := c.httpClient().Post(c.url("/downloads"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusAccepted {
+		return ErrBotOffline
+	}
+
+	return checkResponse(response, http.StatusCreated)
+}
+
+// Downloads returns all downloads known to the daemon.
+func (c *Client) Downloads() ([]xdcc.DownloadJSON, error) {
+	downloads := []xdcc.DownloadJSON{}
+
+	return downloads, c.getJSON("/downloads", &downloads)
+}
+
+// Status returns status of the daemon's networks.
+func (c *Client) Status() (Status, error) {
+	status := Status{}
+
+	return status, c.getJSON("/status", &status)
+}
+
+func (c *Client) getJSON(path string, value interface{}) error {
+	response, err := c.httpClient().Get(c.url(path))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	err = checkResponse(response, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+func (c *Client) url(path string) string {
+	return strings.TrimSuffix(c.BaseURL, "/") + path
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+func checkResponse(response *http.Response, expectedStatus int) error {
+	if response.StatusCode == expectedStatus {
+		return nil
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+
+	return &RequestError{StatusCode: response.StatusCode, Message: strings.TrimSpace(string(message))}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDownloadsEngine struct {
+	fakeXdccEngine
+}
+
+func (e *fakeDownloadsEngine) DownloadsJSON(writer io.Writer) error {
+	writer.Write([]byte(`[{"FileName":"foo.mkv","Status":1,"Size":100,"Downloaded":50,"BotNick":"b0t"}]`))
+	return nil
+}
+
+type fakeNetworksReporter struct{}
+
+func (s *fakeNetworksReporter) StatusJSON(writer io.Writer) error {
+	return json.NewEncoder(writer).Encode(Status{Networks: []NetworkStatus{{Name: "rizon"}}})
+}
+
+func TestClientRequestFile(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	server := httptest.NewServer(NewRouter(engine, nil))
+	defer server.Close()
+	client := &Client{BaseURL: server.URL + "/"}
+
+	err := client.RequestFile("rizon", "b0t", 42, "foo.mkv")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rizon|b0t|42|foo.mkv"}, engine.Requested)
+}
+
+func TestClientRequestFileRejected(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	server := httptest.NewServer(NewRouter(engine, nil))
+	defer server.Close()
+	client := &Client{BaseURL: server.URL}
+
+	err := client.RequestFile("unknown", "b0t", 42, "foo.mkv")
+
+	requestError, ok := err.(*RequestError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, requestError.StatusCode)
+	assert.Equal(t, "Unknown network", requestError.Message)
+}
+
+func TestClientRequestFileBotOffline(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	server := httptest.NewServer(NewRouter(engine, nil))
+	defer server.Close()
+	client := &Client{BaseURL: server.URL}
+
+	err := client.RequestFile("rizon", "offline", 42, "foo.mkv")
+
+	assert.Equal(t, ErrBotOffline, err)
+	assert.Equal(t, []string{"rizon|offline|42|foo.mkv"}, engine.Requested)
+}
+
+func TestClientDownloads(t *testing.T) {
+	engine := &fakeDownloadsEngine{}
+	server := httptest.NewServer(NewRouter(engine, nil))
+	defer server.Close()
+	client := &Client{BaseURL: server.URL}
+
+	downloads, err := client.Downloads()
+
+	assert.Nil(t, err)
+	assert.Len(t, downloads, 1)
+	assert.Equal(t, "foo.mkv", downloads[0].FileName)
+	assert.Equal(t, "b0t", downloads[0].BotNick)
+	assert.Equal(t, uint64(50), downloads[0].Downloaded)
+}
+
+func TestClientStatus(t *testing.T) {
+	engine := &fakeXdccEngine{}
+	server := httptest.NewServer(NewRouter(engine, &fakeNetworksReporter{}))
+	defer server.Close()
+	client := &Client{BaseURL: server.URL}
+
+	status, err := client.Status()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "rizon", status.Networks[0].Name)
+
+	server.Close()
+	_, err = client.Status()
+
+	assert.NotNil(t, err)
+}
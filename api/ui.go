@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	uiIndex = "/index.html"
+	// uiStaticPrefix is where the build puts assets with content hashes in names.
+	uiStaticPrefix = "/static/"
+)
+
+// UI describes how the built web app is served next to the API.
+type UI struct {
+	// Files of the built web app. Nil disables serving it.
+	Files http.FileSystem
+	// CORS allows cross-origin API requests, e.g. from separately deployed web app.
+	CORS bool
+}
+
+// uiHandler serves files of the web app. Paths which do not match
+// any file are served with index.html, so the app can route them itself.
+func uiHandler(files http.FileSystem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := path.Clean("/" + r.URL.Path)
+		file, info, err := openUIFile(files, name)
+		if err == nil && info.IsDir() {
+			file.Close()
+			name = path.Join(name, uiIndex)
+			file, info, err = openUIFile(files, name)
+		}
+		if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+			if file != nil {
+				file.Close()
+			}
+			name = uiIndex
+			file, info, err = openUIFile(files, name)
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+
+		if strings.HasPrefix(name, uiStaticPrefix) {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+
+		http.ServeContent(w, r, name, info.ModTime(), file)
+	})
+}
+
+func openUIFile(files http.FileSystem, name string) (http.File, os.FileInfo, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, info, nil
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"animuxd/webui"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testUIFiles = webui.Bundle{
+	"/index.html":            []byte("<html>animuxd</html>"),
+	"/static/js/main.abc.js": []byte("console.log()"),
+	"/favicon.ico":           []byte("icon"),
+}
+
+func serveUI(ui UI, method string, path string) *httptest.ResponseRecorder {
+	engine := &fakeXdccEngine{}
+	engine.Start()
+	router := NewRouterWithUI(engine, nil, ui)
+
+	r, _ := http.NewRequest(method, path, nil)
+	r.Header.Set("Origin", "http://example.com")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestUIIndex(t *testing.T) {
+	w := serveUI(UI{Files: testUIFiles}, "GET", "/")
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "<html>animuxd</html>", w.Body.String())
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+}
+
+func TestUIStaticFile(t *testing.T) {
+	w := serveUI(UI{Files: testUIFiles}, "GET", "/static/js/main.abc.js")
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "console.log()", w.Body.String())
+	assert.Contains(t, w.Header().Get("Cache-Control"), "immutable")
+
+	w = serveUI(UI{Files: testUIFiles}, "GET", "/favicon.ico")
+
+	assert.Equal(t, "icon", w.Body.String())
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+}
+
+func TestUIFallsBackToIndex(t *testing.T) {
+	w := serveUI(UI{Files: testUIFiles}, "GET", "/search/foo")
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "<html>animuxd</html>", w.Body.String())
+}
+
+func TestUIDoesNotShadowAPI(t *testing.T) {
+	w := serveUI(UI{Files: testUIFiles}, "GET", "/downloads")
+
+	assert.Equal(t, `[{"foo":"bar"}]`, w.Body.String())
+
+	w = serveUI(UI{Files: testUIFiles}, "POST", "/")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Result().StatusCode)
+}
+
+func TestUIWithoutFiles(t *testing.T) {
+	w := serveUI(UI{}, "GET", "/")
+
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestCORS(t *testing.T) {
+	w := serveUI(UI{CORS: true}, "GET", "/downloads")
+
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = serveUI(UI{CORS: false}, "GET", "/downloads")
+
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"animuxd/api"
+	"animuxd/config"
+	"animuxd/xdcc"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes of the client subcommands.
+const (
+	exitOK = 0
+	// exitFailure is returned on unexpected errors and when status reports a disconnected network.
+	exitFailure = 1
+	exitUsage   = 2
+	// exitUnreachable is returned when the daemon's API cannot be reached.
+	exitUnreachable = 3
+	// exitRejected is returned when the API rejects a request, e.g. for an unknown network.
+	exitRejected = 4
+	// exitDownloadFailed is returned when a watched download fails or gets interrupted.
+	exitDownloadFailed = 5
+	// exitBotOffline is returned when the bot is unknown or offline, so downloads wait for it.
+	exitBotOffline = 6
+)
+
+// clientFlags are flags shared by all subcommands talking to the API.
+type clientFlags struct {
+	*flag.FlagSet
+	api  *string
+	json *bool
+}
+
+func newClientFlags(name string, summary string, stderr io.Writer) *clientFlags {
+	flags := &clientFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [flags] %s\n", name, summary)
+		flags.PrintDefaults()
+	}
+
+	defaultAPI := os.Getenv("ANIMUXD_API_URL")
+	if defaultAPI == "" {
+		defaultAPI = "http://" + config.Default().API.Listen
+	}
+	flags.api = flags.String("api", defaultAPI, "URL of the daemon's API")
+	flags.json = flags.Bool("json", false, "print JSON instead of tables")
+
+	return flags
+}
+
+func (f *clientFlags) client() *api.Client {
+	return &api.Client{BaseURL: *f.api}
+}
+
+// clientErrorCode prints the error and translates it to an exit code.
+func clientErrorCode(err error, stderr io.Writer) int {
+	fmt.Fprintln(stderr, err)
+
+	if errors.Is(err, api.ErrBotOffline) {
+		return exitBotOffline
+	}
+	var requestError *api.RequestError
+	if errors.As(err, &requestError) {
+		return exitRejected
+	}
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		return exitUnreachable
+	}
+
+	return exitFailure
+}
+
+// add requests a package of a bot and, if asked to, waits until it gets downloaded.
+func add(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newClientFlags("animuxd add", "<bot> <package> <file>", stderr)
+	networkName := flags.String("network", "", "name of the network of the bot, the default one when empty")
+	wait := flags.Bool("wait", false, "wait until the download finishes")
+	interval := flags.Int64("interval", 1000, "how often to check the download when waiting, in milliseconds")
+
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	packageNo, err := strconv.Atoi(flags.Arg(1))
+	if flags.NArg() != 3 || err != nil || packageNo <= 0 || flags.Arg(2) == "" || *interval <= 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	botNick, fileName := flags.Arg(0), flags.Arg(2)
+
+	client := flags.client()
+	err = client.RequestFile(*networkName, botNick, packageNo, fileName)
+	if err != nil {
+		return clientErrorCode(err, stderr)
+	}
+
+	if *flags.json {
+		json.NewEncoder(stdout).Encode(struct {
+			Network       string
+			BotNick       string
+			PackageNumber int
+			FileName      string
+		}{*networkName, botNick, packageNo, fileName})
+	} else {
+		fmt.Fprintf(stdout, "Requested %s from %s #%d\n", fileName, botNick, packageNo)
+	}
+
+	if !*wait {
+		return exitOK
+	}
+
+	return watchDownloads(client, []string{fileName}, *flags.json, time.Duration(*interval)*time.Millisecond, stdout, stderr)
+}
+
+// list prints all downloads.
+func list(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newClientFlags("animuxd list", "", stderr)
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+
+	downloads, err := flags.client().Downloads()
+	if err != nil {
+		return clientErrorCode(err, stderr)
+	}
+	sort.Slice(downloads, func(i, j int) bool { return downloads[i].FileName < downloads[j].FileName })
+
+	if *flags.json {
+		json.NewEncoder(stdout).Encode(downloads)
+		return exitOK
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "FILE\tNETWORK\tBOT\tPACKAGE\tSTATUS\tPROGRESS\tSPEED")
+	for _, download := range downloads {
+		fmt.Fprintf(
+			table, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			download.FileName, download.Network, download.BotNick, download.PackageNo,
+			download.Status, progress(download), formatBytes(download.CurrentSpeed)+"/s",
+		)
+	}
+	table.Flush()
+
+	return exitOK
+}
+
+// watch prints changes of downloads until all of them finish.
+func watch(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newClientFlags("animuxd watch", "[file...]", stderr)
+	interval := flags.Int64("interval", 1000, "how often to check downloads, in milliseconds")
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	if *interval <= 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	return watchDownloads(flags.client(), flags.Args(), *flags.json, time.Duration(*interval)*time.Millisecond, stdout, stderr)
+}
+
+// watchDownloads polls downloads under given names, or all unfinished ones when no names are given,
+// and prints every change. Returns exitDownloadFailed when any of them does not finish successfully
+// and exitBotOffline when the unfinished ones only wait for their bots to come online.
+func watchDownloads(client *api.Client, fileNames []string, asJSON bool, interval time.Duration, stdout io.Writer, stderr io.Writer) int {
+	watched := map[string]bool{}
+	for _, fileName := range fileNames {
+		watched[fileName] = true
+	}
+	reported := map[string]string{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		downloads, err := client.Downloads()
+		if err != nil {
+			return clientErrorCode(err, stderr)
+		}
+		sort.Slice(downloads, func(i, j int) bool { return downloads[i].FileName < downloads[j].FileName })
+
+		byName := map[string]xdcc.DownloadJSON{}
+		for _, download := range downloads {
+			byName[download.FileName] = download
+			if first && len(fileNames) == 0 && !finished(download.Status) {
+				watched[download.FileName] = true
+			}
+		}
+
+		pending, parked, failed := 0, 0, 0
+		for _, download := range downloads {
+			if !watched[download.FileName] {
+				continue
+			}
+
+			line := fmt.Sprintf("%s\t%s\t%s", download.FileName, download.Status, progress(download))
+			if download.Status != xdcc.Downloading && download.Status != xdcc.Done {
+				for _, message := range []string{download.BotMessage, download.Error} {
+					if message != "" {
+						line += "\t" + message
+					}
+				}
+			}
+			if reported[download.FileName] != line {
+				reported[download.FileName] = line
+				if asJSON {
+					json.NewEncoder(stdout).Encode(download)
+				} else {
+					fmt.Fprintln(stdout, line)
+				}
+			}
+
+			if !finished(download.Status) {
+				pending++
+				if download.Status == xdcc.WaitingForBot {
+					parked++
+				}
+			} else if download.Status != xdcc.Done {
+				failed++
+			}
+		}
+		for fileName := range watched {
+			if _, ok := byName[fileName]; !ok {
+				fmt.Fprintf(stderr, "Unknown download %s\n", fileName)
+				return exitFailure
+			}
+		}
+
+		if pending == 0 {
+			if failed > 0 {
+				return exitDownloadFailed
+			}
+			return exitOK
+		}
+		if pending == parked {
+			return exitBotOffline
+		}
+
+		<-ticker.C
+	}
+}
+
+// status prints state of the daemon's networks.
+func status(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newClientFlags("animuxd status", "", stderr)
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+
+	daemonStatus, err := flags.client().Status()
+	if err != nil {
+		return clientErrorCode(err, stderr)
+	}
+
+	if *flags.json {
+		json.NewEncoder(stdout).Encode(daemonStatus)
+	} else {
+		table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "NETWORK\tCONNECTED\tNICK\tAUTH\tRECONNECTS\tLAG")
+		for _, network := range daemonStatus.Networks {
+			lag := "-"
+			if network.LagMsec > 0 {
+				lag = fmt.Sprintf("%dms", network.LagMsec)
+			}
+			fmt.Fprintf(
+				table, "%s\t%t\t%s\t%s\t%d\t%s\n",
+				network.Name, network.Connected, network.Nick, network.Auth, network.Reconnects, lag,
+			)
+		}
+		table.Flush()
+	}
+
+	for _, network := range daemonStatus.Networks {
+		if !network.Connected {
+			return exitFailure
+		}
+	}
+
+	return exitOK
+}
+
+func finished(status xdcc.DownloadStatus) bool {
+	return status == xdcc.Done || status == xdcc.Failed || status == xdcc.Interrupted
+}
+
+func progress(download xdcc.DownloadJSON) string {
+	if download.Size <= 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(download.Downloaded)*100/float64(download.Size))
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value, exponent := float64(n)/unit, 0
+	for value >= unit && exponent < 3 {
+		value /= unit
+		exponent++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exponent])
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPI serves downloads responses one after another, repeating the last one.
+type fakeAPI struct {
+	downloads []string
+	status    string
+	requests  []string
+	mutex     sync.Mutex
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/downloads":
+		body, _ := ioutil.ReadAll(r.Body)
+		a.requests = append(a.requests, string(body))
+		if bytes.Contains(body, []byte(`"Network":"unknown"`)) {
+			http.Error(w, "Unknown network", http.StatusBadRequest)
+			return
+		}
+		if bytes.Contains(body, []byte(`"BotNick":"offline"`)) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.URL.Path == "/downloads":
+		fmt.Fprint(w, a.downloads[0])
+		if len(a.downloads) > 1 {
+			a.downloads = a.downloads[1:]
+		}
+	case r.URL.Path == "/status":
+		fmt.Fprint(w, a.status)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func runClient(t *testing.T, handler http.Handler, args ...string) (int, string, string) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run(append([]string{args[0], "-api", server.URL}, args[1:]...), stdout, stderr)
+
+	return code, stdout.String(), stderr.String()
+}
+
+func TestAdd(t *testing.T) {
+	api := &fakeAPI{}
+
+	code, stdout, _ := runClient(t, api, "add", "-network", "rizon", "b0t", "42", "foo.mkv")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "Requested foo.mkv from b0t #42\n", stdout)
+	assert.Equal(t, []string{`{"Network":"rizon","BotNick":"b0t","PackageNumber":42,"FileName":"foo.mkv"}`}, api.requests)
+}
+
+func TestAddRejected(t *testing.T) {
+	code, _, stderr := runClient(t, &fakeAPI{}, "add", "-network", "unknown", "b0t", "42", "foo.mkv")
+
+	assert.Equal(t, exitRejected, code)
+	assert.Contains(t, stderr, "Unknown network")
+}
+
+func TestAddBotOffline(t *testing.T) {
+	code, _, stderr := runClient(t, &fakeAPI{}, "add", "-wait", "offline", "42", "foo.mkv")
+
+	assert.Equal(t, exitBotOffline, code)
+	assert.Contains(t, stderr, "Bot is offline")
+}
+
+func TestAddUsage(t *testing.T) {
+	code, _, stderr := runClient(t, &fakeAPI{}, "add", "b0t", "foo.mkv")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: animuxd add")
+}
+
+func TestAddInvalidInterval(t *testing.T) {
+	code, _, stderr := runClient(t, &fakeAPI{}, "add", "-wait", "-interval", "0", "b0t", "42", "foo.mkv")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: animuxd add")
+}
+
+func TestAddUnreachable(t *testing.T) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+
+	code := run([]string{"add", "-api", "http://127.0.0.1:1", "b0t", "42", "foo.mkv"}, stdout, stderr)
+
+	assert.Equal(t, exitUnreachable, code)
+}
+
+func TestAddWait(t *testing.T) {
+	api := &fakeAPI{downloads: []string{
+		`[{"FileName":"foo.mkv","Status":1,"Size":100,"Downloaded":50}]`,
+		`[{"FileName":"foo.mkv","Status":2,"Size":100,"Downloaded":100}]`,
+	}}
+
+	code, stdout, _ := runClient(t, api, "add", "-wait", "-interval", "10", "b0t", "42", "foo.mkv")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "foo.mkv\tdownloading\t50.0%\n")
+	assert.Contains(t, stdout, "foo.mkv\tdone\t100.0%\n")
+}
+
+func TestList(t *testing.T) {
+	api := &fakeAPI{downloads: []string{
+		`[{"FileName":"foo.mkv","Status":1,"Size":100,"Downloaded":50,"CurrentSpeed":2048,"Network":"rizon","BotNick":"b0t","PackageNo":42}]`,
+	}}
+
+	code, stdout, _ := runClient(t, api, "list")
+
+	assert.Equal(t, exitOK, code)
+	assert.Regexp(t, `FILE\s+NETWORK\s+BOT\s+PACKAGE\s+STATUS\s+PROGRESS\s+SPEED`, stdout)
+	assert.Regexp(t, `foo.mkv\s+rizon\s+b0t\s+42\s+downloading\s+50.0%\s+2.0 KiB/s`, stdout)
+
+	code, stdout, _ = runClient(t, api, "list", "-json")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, `"FileName":"foo.mkv"`)
+}
+
+func TestWatchFailed(t *testing.T) {
+	api := &fakeAPI{downloads: []string{
+		`[{"FileName":"foo.mkv","Status":0},{"FileName":"bar.mkv","Status":2}]`,
+		`[{"FileName":"foo.mkv","Status":3},{"FileName":"bar.mkv","Status":2}]`,
+	}}
+
+	code, stdout, _ := runClient(t, api, "watch", "-interval", "10")
+
+	assert.Equal(t, exitDownloadFailed, code)
+	assert.Equal(t, "foo.mkv\twaiting\t-\nfoo.mkv\tfailed\t-\n", stdout)
+}
+
+func TestWatchShowsBotMessage(t *testing.T) {
+	api := &fakeAPI{downloads: []string{
+		`[{"FileName":"foo.mkv","Status":5,"BotMessage":"Added you to the main queue"}]`,
+		`[{"FileName":"foo.mkv","Status":3,"BotMessage":"Invalid Pack Number"}]`,
+	}}
+
+	code, stdout, _ := runClient(t, api, "watch", "-interval", "10", "foo.mkv")
+
+	assert.Equal(t, exitDownloadFailed, code)
+	assert.Equal(t, "foo.mkv\tdeferred\t-\tAdded you to the main queue\nfoo.mkv\tfailed\t-\tInvalid Pack Number\n", stdout)
+}
+
+func TestWatchShowsError(t *testing.T) {
+	api := &fakeAPI{downloads: []string{
+		`[{"FileName":"foo.mkv","Status":3,"Error":"Bot is offline"}]`,
+	}}
+
+	code, stdout, _ := runClient(t, api, "watch", "-interval", "10", "foo.mkv")
+
+	assert.Equal(t, exitDownloadFailed, code)
+	assert.Equal(t, "foo.mkv\tfailed\t-\tBot is offline\n", stdout)
+}
+
+func TestWatchStopsWhenBotsAreOffline(t *testing.T) {
+	api := &fakeAPI{downloads: []string{
+		`[{"FileName":"foo.mkv","Status":1},{"FileName":"bar.mkv","Status":6,"Error":"Bot is offline"}]`,
+		`[{"FileName":"foo.mkv","Status":2},{"FileName":"bar.mkv","Status":6,"Error":"Bot is offline"}]`,
+	}}
+
+	code, stdout, _ := runClient(t, api, "watch", "-interval", "10")
+
+	assert.Equal(t, exitBotOffline, code)
+	assert.Contains(t, stdout, "bar.mkv\twaiting for bot\t-\tBot is offline\n")
+	assert.Contains(t, stdout, "foo.mkv\tdone\t-\n")
+}
+
+func TestWatchInvalidInterval(t *testing.T) {
+	code, _, stderr := runClient(t, &fakeAPI{}, "watch", "-interval", "-1")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: animuxd watch")
+}
+
+func TestWatchUnknownDownload(t *testing.T) {
+	api := &fakeAPI{downloads: []string{`[]`}}
+
+	code, _, stderr := runClient(t, api, "watch", "foo.mkv")
+
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "Unknown download foo.mkv")
+}
+
+func TestStatus(t *testing.T) {
+	api := &fakeAPI{status: `{"Networks":[{"Name":"rizon","Connected":true,"Nick":"ownadi","Auth":"sasl","Reconnects":2,"LagMsec":120}]}`}
+
+	code, stdout, _ := runClient(t, api, "status")
+
+	assert.Equal(t, exitOK, code)
+	assert.Regexp(t, `rizon\s+true\s+ownadi\s+sasl\s+2\s+120ms`, stdout)
+}
+
+func TestStatusDisconnected(t *testing.T) {
+	api := &fakeAPI{status: `{"Networks":[{"Name":"rizon","Connected":false}]}`}
+
+	code, stdout, _ := runClient(t, api, "status", "-json")
+
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, `"Connected":false`)
+}
@@ -0,0 +1,489 @@
+package config
+
+import (
+	"animuxd/irc"
+	"animuxd/transport"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is a prefix of environment variables that override the configuration.
+const EnvPrefix = "ANIMUXD"
+
+const passwordMask = "********"
+
+// Config is a resolved configuration of the daemon.
+type Config struct {
+	Networks []Network `yaml:"networks"`
+	Unsafe   bool      `yaml:"unsafe"`
+	Identity Identity  `yaml:"identity"`
+	Timeouts Timeouts  `yaml:"timeouts"`
+	Flood    Flood     `yaml:"flood"`
+	Paths    Paths     `yaml:"paths"`
+	DCC      DCC       `yaml:"dcc"`
+	API      API       `yaml:"api"`
+}
+
+// Network describes a single IRC network. The first network is the default one.
+type Network struct {
+	Name   string `yaml:"name"`
+	Server string `yaml:"server"`
+	TLS    TLS    `yaml:"tls"`
+	// Nicks are tried in order before falling back to random ones.
+	Nicks []string `yaml:"nicks"`
+	Auth  Auth     `yaml:"auth"`
+	// Capabilities are IRCv3 capabilities requested when the server offers them.
+	// When not set, DefaultCapabilities are requested. Empty list disables negotiation.
+	Capabilities []string `yaml:"capabilities"`
+	// Proxy is used for both IRC and DCC connections of the network.
+	Proxy Proxy `yaml:"proxy"`
+	// Encoding decodes lines which are not valid UTF-8, e.g. cp1252 (the default) or latin1.
+	Encoding string `yaml:"encoding"`
+	// DCCEncryption is empty, prefer or require. Both request DCC SSEND from bots,
+	// prefer falls back to DCC SEND when bots do not answer, require rejects unencrypted transfers.
+	DCCEncryption string `yaml:"dcc_encryption"`
+}
+
+// Proxy describes a SOCKS5 or HTTP CONNECT proxy. Empty type disables it.
+type Proxy struct {
+	Type     string `yaml:"type"`
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// TLS describes how connection to a network is secured.
+// Server certificate is verified against system roots unless it is pinned
+// or verification is skipped.
+type TLS struct {
+	Enabled            bool   `yaml:"enabled"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	PinSHA256          string `yaml:"pin_sha256"`
+	ClientCert         string `yaml:"client_cert"`
+	ClientKey          string `yaml:"client_key"`
+}
+
+// Auth describes how the daemon authenticates to network services.
+// SASL is used when a mechanism is set, NickServ when SASL is disabled or fails.
+type Auth struct {
+	SASLMechanism string `yaml:"sasl_mechanism"`
+	Account       string `yaml:"account"`
+	Password      string `yaml:"password"`
+	NickServ      bool   `yaml:"nickserv"`
+}
+
+// Identity describes how the daemon presents itself on IRC.
+type Identity struct {
+	NickLength int `yaml:"nick_length"`
+	// Version is sent in reply to CTCP VERSION.
+	Version string `yaml:"version"`
+}
+
+// Timeouts groups all timeouts, in milliseconds.
+type Timeouts struct {
+	DialMsec     int64 `yaml:"dial_msec"`
+	RegisterMsec int64 `yaml:"register_msec"`
+	RequestMsec  int64 `yaml:"request_msec"`
+	// Reconnect backoff grows exponentially from min to max.
+	ReconnectMinMsec int64 `yaml:"reconnect_min_msec"`
+	ReconnectMaxMsec int64 `yaml:"reconnect_max_msec"`
+	// Running transfers are given that much time to finish on shutdown.
+	ShutdownGraceMsec int64 `yaml:"shutdown_grace_msec"`
+	// Bots offering passive DCC are given that much time to connect.
+	PassiveDCCMsec int64 `yaml:"passive_dcc_msec"`
+	// Servers are PINGed that often to detect dead connections. Zero disables it.
+	PingIntervalMsec int64 `yaml:"ping_interval_msec"`
+	// Connections are re-established when PONG does not come back in that time.
+	PingTimeoutMsec int64 `yaml:"ping_timeout_msec"`
+	// Channels are rejoined after kicks with backoff growing from min to max. Zero min disables it.
+	RejoinMinMsec int64 `yaml:"rejoin_min_msec"`
+	RejoinMaxMsec int64 `yaml:"rejoin_max_msec"`
+	// Bots are looked up with ISON that often on servers without MONITOR.
+	PresencePollMsec int64 `yaml:"presence_poll_msec"`
+	// Networks preferring encryption request plain DCC SEND when bots do not answer SSEND in that time.
+	SSendFallbackMsec int64 `yaml:"ssend_fallback_msec"`
+}
+
+// Flood limits lines sent to IRC servers, so they do not disconnect us for excess flood.
+// Burst lines are sent at once, then one line per interval. Zero burst disables the limit.
+type Flood struct {
+	Burst        int   `yaml:"burst"`
+	IntervalMsec int64 `yaml:"interval_msec"`
+}
+
+// Paths groups filesystem locations.
+type Paths struct {
+	DownloadDir string `yaml:"download_dir"`
+	// StateFile keeps downloads between restarts. Empty disables persistence.
+	StateFile string `yaml:"state_file"`
+}
+
+// DCC describes how files are received. Passive offers, where the bot connects to us,
+// require the external IP and, behind NAT, a forwarded port range.
+type DCC struct {
+	ExternalIP string `yaml:"external_ip"`
+	// Both ports zero let the system pick any free port.
+	PassivePortMin int `yaml:"passive_port_min"`
+	PassivePortMax int `yaml:"passive_port_max"`
+}
+
+// API describes the HTTP API server.
+type API struct {
+	Listen string `yaml:"listen"`
+	// UIDir is a directory of the built web app served next to the API.
+	UIDir string `yaml:"ui_dir"`
+	// EmbeddedUI serves the web app bundled into the binary instead.
+	EmbeddedUI bool `yaml:"embedded_ui"`
+	// CORS allows cross-origin requests, e.g. from separately deployed web app.
+	CORS bool `yaml:"cors"`
+}
+
+// DefaultCapabilities are requested on networks that do not list capabilities.
+var DefaultCapabilities = []string{"server-time", "message-tags", "account-tag", "away-notify", "batch"}
+
+// Default returns configuration used when nothing else is specified.
+func Default() Config {
+	return Config{
+		Networks: []Network{
+			{Name: "rizon", Server: "irc.rizon.net:6667", Capabilities: DefaultCapabilities},
+		},
+		Identity: Identity{
+			NickLength: 7,
+			Version:    "animuxd",
+		},
+		Timeouts: Timeouts{
+			DialMsec:          10000,
+			RegisterMsec:      10000,
+			RequestMsec:       2000,
+			ReconnectMinMsec:  1000,
+			ReconnectMaxMsec:  300000,
+			ShutdownGraceMsec: 30000,
+			PassiveDCCMsec:    60000,
+			PingIntervalMsec:  60000,
+			PingTimeoutMsec:   30000,
+			RejoinMinMsec:     5000,
+			RejoinMaxMsec:     300000,
+			PresencePollMsec:  30000,
+			SSendFallbackMsec: 30000,
+		},
+		Flood: Flood{
+			Burst:        5,
+			IntervalMsec: 2000,
+		},
+		Paths: Paths{
+			DownloadDir: ".",
+			StateFile:   "animuxd-state.json",
+		},
+		API: API{
+			Listen: "127.0.0.1:1337",
+			CORS:   true,
+		},
+	}
+}
+
+// Load reads configuration file under given path on top of the defaults
+// and applies environment overrides. Empty path skips the file.
+func Load(path string) (Config, error) {
+	c := Default()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+
+		err = yaml.UnmarshalStrict(data, &c)
+		if err != nil {
+			return c, err
+		}
+	}
+	for i := range c.Networks {
+		if c.Networks[i].Capabilities == nil {
+			c.Networks[i].Capabilities = DefaultCapabilities
+		}
+	}
+
+	err := c.ApplyEnv(os.LookupEnv)
+	return c, err
+}
+
+// Write writes YAML representation of the configuration to given writer.
+// Passwords are masked.
+func (c *Config) Write(writer io.Writer) error {
+	masked := *c
+	masked.Networks = make([]Network, len(c.Networks))
+	for i, network := range c.Networks {
+		if network.Auth.Password != "" {
+			network.Auth.Password = passwordMask
+		}
+		if network.Proxy.Password != "" {
+			network.Proxy.Password = passwordMask
+		}
+		masked.Networks[i] = network
+	}
+
+	data, err := yaml.Marshal(masked)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(data)
+	return err
+}
+
+// ApplyEnv overrides values with environment variables named after yaml keys,
+// e.g. ANIMUXD_TIMEOUTS_DIAL_MSEC overrides timeouts.dial_msec.
+// Elements of lists are addressed by their names, e.g. ANIMUXD_NETWORKS_RIZON_SERVER.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, lookup)
+}
+
+func applyEnv(value reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := value.Field(i)
+		key := strings.SplitN(valueType.Field(i).Tag.Get("yaml"), ",", 2)[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := fmt.Sprintf("%s_%s", prefix, strings.ToUpper(key))
+
+		if field.Kind() == reflect.Struct {
+			err := applyEnv(field, name, lookup)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct {
+			for j := 0; j < field.Len(); j++ {
+				element := field.Index(j)
+				elementName := element.FieldByName("Name")
+				if !elementName.IsValid() || elementName.Kind() != reflect.String {
+					continue
+				}
+
+				elementPrefix := fmt.Sprintf("%s_%s", name, envName(elementName.String()))
+				err := applyEnv(element, elementPrefix, lookup)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+
+		err := setFromString(field, raw)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+var envUnsafePattern = regexp.MustCompile("[^A-Z0-9]")
+
+func envName(name string) string {
+	return envUnsafePattern.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+func setFromString(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(v)
+	case reflect.Int, reflect.Int64:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(v)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.New("Can not be set from environment")
+		}
+		parts := make([]string, 0)
+		for _, part := range strings.Split(raw, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		field.Set(reflect.ValueOf(parts))
+	default:
+		return errors.New("Can not be set from environment")
+	}
+
+	return nil
+}
+
+var networkNamePattern = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+var nickPattern = regexp.MustCompile("^[A-Za-z\\[\\]\\\\`_^{|}][A-Za-z0-9\\[\\]\\\\`_^{|}-]*$")
+
+// Validate returns all problems found in the configuration.
+func (c *Config) Validate() []error {
+	errs := make([]error, 0)
+
+	if len(c.Networks) == 0 {
+		errs = append(errs, errors.New("networks: at least one network is required"))
+	}
+	names := map[string]bool{}
+	for i, network := range c.Networks {
+		if !networkNamePattern.MatchString(network.Name) {
+			errs = append(errs, fmt.Errorf("networks[%d].name: must consist of letters, digits, - and _", i))
+		} else if names[network.Name] {
+			errs = append(errs, fmt.Errorf("networks[%d].name: %s is not unique", i, network.Name))
+		}
+		names[network.Name] = true
+
+		if _, _, err := net.SplitHostPort(network.Server); err != nil {
+			errs = append(errs, fmt.Errorf("networks[%d].server: %v", i, err))
+		}
+		if network.TLS.PinSHA256 != "" {
+			if _, err := transport.ParseFingerprint(network.TLS.PinSHA256); err != nil {
+				errs = append(errs, fmt.Errorf("networks[%d].tls.pin_sha256: %v", i, err))
+			}
+		}
+		if (network.TLS.ClientCert == "") != (network.TLS.ClientKey == "") {
+			errs = append(errs, fmt.Errorf("networks[%d].tls: client_cert and client_key must be set together", i))
+		}
+		for j, nick := range network.Nicks {
+			if !nickPattern.MatchString(nick) {
+				errs = append(errs, fmt.Errorf("networks[%d].nicks[%d]: %s is not a valid nick", i, j, nick))
+			}
+		}
+		for j, capability := range network.Capabilities {
+			if capability == "" || strings.ContainsAny(capability, " =") {
+				errs = append(errs, fmt.Errorf("networks[%d].capabilities[%d]: %q is not a valid capability", i, j, capability))
+			}
+		}
+		errs = append(errs, network.Auth.validate(fmt.Sprintf("networks[%d]", i), network.TLS)...)
+		switch network.Proxy.Type {
+		case "":
+		case transport.ProxySOCKS5, transport.ProxyHTTP:
+			if _, _, err := net.SplitHostPort(network.Proxy.Address); err != nil {
+				errs = append(errs, fmt.Errorf("networks[%d].proxy.address: %v", i, err))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("networks[%d].proxy.type: must be socks5 or http", i))
+		}
+		if !irc.ValidEncoding(network.Encoding) {
+			errs = append(errs, fmt.Errorf("networks[%d].encoding: must be cp1252, latin1 or utf-8", i))
+		}
+		switch network.DCCEncryption {
+		case "", "prefer", "require":
+		default:
+			errs = append(errs, fmt.Errorf("networks[%d].dcc_encryption: must be prefer or require", i))
+		}
+	}
+	if c.Identity.NickLength < 1 || c.Identity.NickLength > 30 {
+		errs = append(errs, errors.New("identity.nick_length: must be between 1 and 30"))
+	}
+	if c.Timeouts.DialMsec <= 0 {
+		errs = append(errs, errors.New("timeouts.dial_msec: must be positive"))
+	}
+	if c.Timeouts.RegisterMsec <= 0 {
+		errs = append(errs, errors.New("timeouts.register_msec: must be positive"))
+	}
+	if c.Timeouts.RequestMsec <= 0 {
+		errs = append(errs, errors.New("timeouts.request_msec: must be positive"))
+	}
+	if c.Timeouts.ReconnectMinMsec <= 0 {
+		errs = append(errs, errors.New("timeouts.reconnect_min_msec: must be positive"))
+	}
+	if c.Timeouts.ReconnectMaxMsec < c.Timeouts.ReconnectMinMsec {
+		errs = append(errs, errors.New("timeouts.reconnect_max_msec: must not be lower than reconnect_min_msec"))
+	}
+	if c.Timeouts.ShutdownGraceMsec < 0 {
+		errs = append(errs, errors.New("timeouts.shutdown_grace_msec: must not be negative"))
+	}
+	if c.Timeouts.PassiveDCCMsec <= 0 {
+		errs = append(errs, errors.New("timeouts.passive_dcc_msec: must be positive"))
+	}
+	if c.Timeouts.PingIntervalMsec < 0 {
+		errs = append(errs, errors.New("timeouts.ping_interval_msec: must not be negative"))
+	}
+	if c.Timeouts.PingTimeoutMsec <= 0 {
+		errs = append(errs, errors.New("timeouts.ping_timeout_msec: must be positive"))
+	}
+	if c.Timeouts.RejoinMinMsec < 0 {
+		errs = append(errs, errors.New("timeouts.rejoin_min_msec: must not be negative"))
+	}
+	if c.Timeouts.RejoinMaxMsec < c.Timeouts.RejoinMinMsec {
+		errs = append(errs, errors.New("timeouts.rejoin_max_msec: must not be lower than rejoin_min_msec"))
+	}
+	if c.Timeouts.PresencePollMsec <= 0 {
+		errs = append(errs, errors.New("timeouts.presence_poll_msec: must be positive"))
+	}
+	if c.Timeouts.SSendFallbackMsec <= 0 {
+		errs = append(errs, errors.New("timeouts.ssend_fallback_msec: must be positive"))
+	}
+	if c.Flood.Burst < 0 {
+		errs = append(errs, errors.New("flood.burst: must not be negative"))
+	}
+	if c.Flood.IntervalMsec <= 0 {
+		errs = append(errs, errors.New("flood.interval_msec: must be positive"))
+	}
+	if c.Paths.DownloadDir == "" {
+		errs = append(errs, errors.New("paths.download_dir: must not be empty"))
+	}
+	if c.DCC.ExternalIP != "" && net.ParseIP(c.DCC.ExternalIP) == nil {
+		errs = append(errs, errors.New("dcc.external_ip: must be an IPv4 or IPv6 address"))
+	}
+	anyPort := c.DCC.PassivePortMin == 0 && c.DCC.PassivePortMax == 0
+	if !anyPort && (c.DCC.PassivePortMin < 1 || c.DCC.PassivePortMax > 65535 || c.DCC.PassivePortMin > c.DCC.PassivePortMax) {
+		errs = append(errs, errors.New("dcc: passive_port_min and passive_port_max must form a range within 1-65535 unless both are zero"))
+	}
+	if _, _, err := net.SplitHostPort(c.API.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("api.listen: %v", err))
+	}
+	if c.API.UIDir != "" && c.API.EmbeddedUI {
+		errs = append(errs, errors.New("api: ui_dir and embedded_ui are mutually exclusive"))
+	}
+
+	return errs
+}
+
+func (a Auth) validate(path string, tls TLS) []error {
+	errs := make([]error, 0)
+
+	switch a.SASLMechanism {
+	case "":
+	case "PLAIN":
+		if a.Account == "" || a.Password == "" {
+			errs = append(errs, fmt.Errorf("%s.auth: PLAIN requires account and password", path))
+		}
+	case "EXTERNAL":
+		if tls.ClientCert == "" {
+			errs = append(errs, fmt.Errorf("%s.auth: EXTERNAL requires tls.client_cert", path))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.auth.sasl_mechanism: must be PLAIN or EXTERNAL", path))
+	}
+	if a.NickServ && a.Password == "" {
+		errs = append(errs, fmt.Errorf("%s.auth: nickserv requires password", path))
+	}
+
+	return errs
+}
@@ -0,0 +1,386 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) (string, func()) {
+	dir, _ := ioutil.TempDir("", "animuxd")
+	path := filepath.Join(dir, "animuxd.yml")
+	ioutil.WriteFile(path, []byte(content), 0644)
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	c := Default()
+
+	assert.Empty(t, c.Validate())
+}
+
+func TestLoad(t *testing.T) {
+	path, cleanup := writeConfigFile(t, `
+networks:
+  - name: foo
+    server: irc.foo.net:6697
+  - name: bar
+    server: irc.bar.net:6667
+timeouts:
+  dial_msec: 500
+`)
+	defer cleanup()
+
+	c, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Network{
+		{Name: "foo", Server: "irc.foo.net:6697", Capabilities: DefaultCapabilities},
+		{Name: "bar", Server: "irc.bar.net:6667", Capabilities: DefaultCapabilities},
+	}, c.Networks)
+	assert.Equal(t, int64(500), c.Timeouts.DialMsec)
+	assert.Equal(t, Default().Timeouts.RegisterMsec, c.Timeouts.RegisterMsec)
+}
+
+func TestLoadCapabilities(t *testing.T) {
+	path, cleanup := writeConfigFile(t, `
+networks:
+  - name: foo
+    server: irc.foo.net:6697
+    capabilities: [server-time]
+  - name: bar
+    server: irc.bar.net:6667
+    capabilities: []
+`)
+	defer cleanup()
+
+	c, err := Load(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"server-time"}, c.Networks[0].Capabilities)
+	assert.NotNil(t, c.Networks[1].Capabilities)
+	assert.Empty(t, c.Networks[1].Capabilities)
+}
+
+func TestValidateNetworkCapabilities(t *testing.T) {
+	c := Default()
+	c.Networks[0].Capabilities = []string{"server-time", "", "sasl=PLAIN"}
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "networks[0].capabilities[1]")
+	assert.Contains(t, errs[1].Error(), "networks[0].capabilities[2]")
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	path, cleanup := writeConfigFile(t, "netwroks: []\n")
+	defer cleanup()
+
+	_, err := Load(path)
+
+	assert.NotNil(t, err)
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, err := Load("/nonexistent/animuxd.yml")
+
+	assert.NotNil(t, err)
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"ANIMUXD_NETWORKS_RIZON_SERVER": "irc.bar.net:6667",
+		"ANIMUXD_UNSAFE":                "true",
+		"ANIMUXD_IDENTITY_NICK_LENGTH":  "9",
+		"ANIMUXD_PATHS_DOWNLOAD_DIR":    "/tmp/foo",
+		"ANIMUXD_TIMEOUTS_REQUEST_MSEC": "100",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	c := Default()
+	err := c.ApplyEnv(lookup)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "irc.bar.net:6667", c.Networks[0].Server)
+	assert.True(t, c.Unsafe)
+	assert.Equal(t, 9, c.Identity.NickLength)
+	assert.Equal(t, "/tmp/foo", c.Paths.DownloadDir)
+	assert.Equal(t, int64(100), c.Timeouts.RequestMsec)
+}
+
+func TestApplyEnvInvalidValue(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "ANIMUXD_TIMEOUTS_DIAL_MSEC" {
+			return "soon", true
+		}
+		return "", false
+	}
+
+	c := Default()
+	err := c.ApplyEnv(lookup)
+
+	assert.Contains(t, err.Error(), "ANIMUXD_TIMEOUTS_DIAL_MSEC")
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Networks[0].Server = "irc.foo.net"
+	c.Identity.NickLength = 0
+	c.Timeouts.DialMsec = -1
+	c.Paths.DownloadDir = ""
+	c.API.Listen = ""
+
+	assert.Len(t, c.Validate(), 5)
+}
+
+func TestValidateNetworks(t *testing.T) {
+	c := Default()
+	c.Networks = []Network{
+		{Name: "foo", Server: "irc.foo.net:6667"},
+		{Name: "foo", Server: "irc.bar.net:6667"},
+		{Name: "b a z", Server: "irc.baz.net:6667"},
+	}
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "networks[1].name")
+	assert.Contains(t, errs[1].Error(), "networks[2].name")
+}
+
+func TestValidateNoNetworks(t *testing.T) {
+	c := Default()
+	c.Networks = nil
+
+	assert.Len(t, c.Validate(), 1)
+}
+
+func TestValidateNetworkTLS(t *testing.T) {
+	c := Default()
+	c.Networks[0].TLS = TLS{Enabled: true, PinSHA256: "foo", ClientCert: "client.crt"}
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "networks[0].tls.pin_sha256")
+	assert.Contains(t, errs[1].Error(), "networks[0].tls")
+}
+
+func TestApplyEnvNetworkTLS(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "ANIMUXD_NETWORKS_RIZON_TLS_ENABLED" {
+			return "true", true
+		}
+		return "", false
+	}
+
+	c := Default()
+	err := c.ApplyEnv(lookup)
+
+	assert.Nil(t, err)
+	assert.True(t, c.Networks[0].TLS.Enabled)
+}
+
+func TestValidateNetworkAuth(t *testing.T) {
+	c := Default()
+	c.Networks = []Network{
+		{Name: "foo", Server: "irc.foo.net:6667", Nicks: []string{"ownadi", "1nick"}, Auth: Auth{SASLMechanism: "PLAIN", Account: "ownadi"}},
+		{Name: "bar", Server: "irc.bar.net:6667", Auth: Auth{SASLMechanism: "EXTERNAL", NickServ: true}},
+		{Name: "baz", Server: "irc.baz.net:6667", Auth: Auth{SASLMechanism: "SCRAM-SHA-256"}},
+	}
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 5)
+	assert.Contains(t, errs[0].Error(), "networks[0].nicks[1]")
+	assert.Contains(t, errs[1].Error(), "networks[0].auth: PLAIN")
+	assert.Contains(t, errs[2].Error(), "networks[1].auth: EXTERNAL")
+	assert.Contains(t, errs[3].Error(), "networks[1].auth: nickserv")
+	assert.Contains(t, errs[4].Error(), "networks[2].auth.sasl_mechanism")
+}
+
+func TestWriteMasksPasswords(t *testing.T) {
+	c := Default()
+	c.Networks[0].Auth = Auth{SASLMechanism: "PLAIN", Account: "ownadi", Password: "secret"}
+	buff := new(bytes.Buffer)
+
+	err := c.Write(buff)
+
+	assert.Nil(t, err)
+	assert.NotContains(t, buff.String(), "secret")
+	assert.Contains(t, buff.String(), "account: ownadi")
+	assert.Equal(t, "secret", c.Networks[0].Auth.Password)
+}
+
+func TestValidateNetworkProxy(t *testing.T) {
+	c := Default()
+	c.Networks = []Network{
+		{Name: "foo", Server: "irc.foo.net:6667", Proxy: Proxy{Type: "socks5", Address: "127.0.0.1:1080"}},
+		{Name: "bar", Server: "irc.bar.net:6667", Proxy: Proxy{Type: "http", Address: "127.0.0.1"}},
+		{Name: "baz", Server: "irc.baz.net:6667", Proxy: Proxy{Type: "socks4", Address: "127.0.0.1:1080"}},
+	}
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "networks[1].proxy.address")
+	assert.Contains(t, errs[1].Error(), "networks[2].proxy.type")
+}
+
+func TestApplyEnvNetworkProxy(t *testing.T) {
+	env := map[string]string{
+		"ANIMUXD_NETWORKS_RIZON_PROXY_TYPE":     "socks5",
+		"ANIMUXD_NETWORKS_RIZON_PROXY_PASSWORD": "secret",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	c := Default()
+	err := c.ApplyEnv(lookup)
+
+	assert.Nil(t, err)
+	assert.Equal(t, Proxy{Type: "socks5", Password: "secret"}, c.Networks[0].Proxy)
+
+	buff := new(bytes.Buffer)
+	c.Write(buff)
+	assert.NotContains(t, buff.String(), "secret")
+}
+
+func TestValidateUI(t *testing.T) {
+	c := Default()
+	c.API.UIDir = "web/build"
+	c.API.EmbeddedUI = true
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "api:")
+}
+
+func TestValidateDCC(t *testing.T) {
+	c := Default()
+	c.DCC = DCC{ExternalIP: "203.0.113.7", PassivePortMin: 50000, PassivePortMax: 50010}
+
+	assert.Empty(t, c.Validate())
+
+	c.DCC = DCC{ExternalIP: "example.org", PassivePortMin: 50010, PassivePortMax: 50000}
+	c.Timeouts.PassiveDCCMsec = 0
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 3)
+	assert.Contains(t, errs[0].Error(), "timeouts.passive_dcc_msec")
+	assert.Contains(t, errs[1].Error(), "dcc.external_ip")
+	assert.Contains(t, errs[2].Error(), "dcc: passive_port_min")
+
+	c = Default()
+	c.DCC = DCC{PassivePortMin: 0, PassivePortMax: 50010}
+
+	errs = c.Validate()
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "dcc: passive_port_min")
+}
+
+func TestValidateNetworkDCCEncryption(t *testing.T) {
+	c := Default()
+	c.Networks = []Network{
+		{Name: "foo", Server: "irc.foo.net:6667", DCCEncryption: "require"},
+		{Name: "bar", Server: "irc.bar.net:6667", DCCEncryption: "always"},
+	}
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "networks[1].dcc_encryption")
+}
+
+func TestValidateNetworkEncoding(t *testing.T) {
+	c := Default()
+	c.Networks = []Network{
+		{Name: "foo", Server: "irc.foo.net:6667", Encoding: "latin1"},
+		{Name: "bar", Server: "irc.bar.net:6667", Encoding: "koi8-r"},
+	}
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "networks[1].encoding")
+}
+
+func TestValidateFlood(t *testing.T) {
+	c := Default()
+	c.Flood = Flood{Burst: 0, IntervalMsec: 500}
+
+	assert.Empty(t, c.Validate())
+
+	c.Flood = Flood{Burst: -1, IntervalMsec: 0}
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "flood.burst")
+	assert.Contains(t, errs[1].Error(), "flood.interval_msec")
+}
+
+func TestValidateRejoin(t *testing.T) {
+	c := Default()
+	c.Timeouts.RejoinMinMsec = 0
+
+	assert.Empty(t, c.Validate())
+
+	c.Timeouts.RejoinMinMsec = 10000
+	c.Timeouts.RejoinMaxMsec = 5000
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "timeouts.rejoin_max_msec")
+}
+
+func TestValidatePresencePoll(t *testing.T) {
+	c := Default()
+	c.Timeouts.PresencePollMsec = 0
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "timeouts.presence_poll_msec")
+}
+
+func TestValidateSSendFallback(t *testing.T) {
+	c := Default()
+	c.Timeouts.SSendFallbackMsec = 0
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "timeouts.ssend_fallback_msec")
+}
+
+func TestValidatePing(t *testing.T) {
+	c := Default()
+	c.Timeouts.PingIntervalMsec = 0
+
+	assert.Empty(t, c.Validate())
+
+	c.Timeouts.PingIntervalMsec = -1
+	c.Timeouts.PingTimeoutMsec = 0
+
+	errs := c.Validate()
+
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "timeouts.ping_interval_msec")
+	assert.Contains(t, errs[1].Error(), "timeouts.ping_timeout_msec")
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"animuxd/api"
+	"animuxd/config"
+	"animuxd/irc"
+	"animuxd/supervisor"
+	"animuxd/transport"
+	"animuxd/webui"
+	"animuxd/xdcc"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A daemon wires irc, xdcc and api packages together.
+type daemon struct {
+	networks    []string
+	supervisors map[string]*supervisor.Supervisor
+	xdccEngine  *xdcc.Engine
+	listener    net.Listener
+	httpServer  *http.Server
+	stateFile   string
+}
+
+// Start connects to IRC networks, registers nicks and starts serving the API.
+func (d *daemon) Start(cfg config.Config) error {
+	if cfg.API.EmbeddedUI && webui.Assets.Empty() {
+		return errors.New("api.embedded_ui: web app is not bundled into this build")
+	}
+
+	err := os.MkdirAll(cfg.Paths.DownloadDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	dialers := map[string]xdcc.Dialer{}
+	encryption := map[string]xdcc.Encryption{}
+	for _, network := range cfg.Networks {
+		if network.Proxy.Type != "" {
+			dialers[network.Name] = dccDialer(dialOptions(cfg, network).Proxy)
+		}
+		encryption[network.Name] = xdcc.Encryption(network.DCCEncryption)
+	}
+
+	d.xdccEngine = &xdcc.Engine{
+		TimeoutMsec:       cfg.Timeouts.RequestMsec,
+		DefaultNetwork:    cfg.Networks[0].Name,
+		Dialers:           dialers,
+		Encryption:        encryption,
+		SSendFallbackMsec: cfg.Timeouts.SSendFallbackMsec,
+		Resumer:           xdcc.FileResumer(cfg.Paths.DownloadDir),
+		Passive: xdcc.Passive{
+			ExternalIP:  net.ParseIP(cfg.DCC.ExternalIP),
+			PortMin:     cfg.DCC.PassivePortMin,
+			PortMax:     cfg.DCC.PassivePortMax,
+			TimeoutMsec: cfg.Timeouts.PassiveDCCMsec,
+		},
+	}
+	d.xdccEngine.Start(xdcc.DialTCP, xdcc.FileWriteOpener(cfg.Paths.DownloadDir), cfg.Unsafe)
+
+	d.networks = make([]string, 0, len(cfg.Networks))
+	d.supervisors = map[string]*supervisor.Supervisor{}
+	for _, network := range cfg.Networks {
+		err = d.startNetwork(cfg, network)
+		if err != nil {
+			d.Stop()
+			return err
+		}
+	}
+
+	d.stateFile = cfg.Paths.StateFile
+	err = d.loadState()
+	if err != nil {
+		d.Stop()
+		return err
+	}
+	d.xdccEngine.RequestInterrupted()
+
+	d.listener, err = net.Listen("tcp", cfg.API.Listen)
+	if err != nil {
+		d.Stop()
+		return err
+	}
+
+	ui := api.UI{CORS: cfg.API.CORS}
+	if cfg.API.UIDir != "" {
+		ui.Files = http.Dir(cfg.API.UIDir)
+	}
+	if cfg.API.EmbeddedUI {
+		ui.Files = webui.Assets
+	}
+
+	d.httpServer = &http.Server{Handler: api.NewRouterWithUI(d.xdccEngine, d, ui)}
+	go d.httpServer.Serve(d.listener)
+
+	return nil
+}
+
+// dialOptions describes how to connect to the network.
+func dialOptions(cfg config.Config, network config.Network) transport.Options {
+	return transport.Options{
+		Timeout: time.Duration(cfg.Timeouts.DialMsec) * time.Millisecond,
+		TLS: transport.TLS{
+			Enabled:            network.TLS.Enabled,
+			InsecureSkipVerify: network.TLS.InsecureSkipVerify,
+			PinSHA256:          network.TLS.PinSHA256,
+			ClientCert:         network.TLS.ClientCert,
+			ClientKey:          network.TLS.ClientKey,
+		},
+		Proxy: transport.Proxy{
+			Type:     network.Proxy.Type,
+			Address:  network.Proxy.Address,
+			Username: network.Proxy.Username,
+			Password: network.Proxy.Password,
+		},
+	}
+}
+
+// dccDialer returns xdcc.Dialer connecting to bots through given proxy.
+func dccDialer(proxy transport.Proxy) xdcc.Dialer {
+	return xdcc.DialThrough(func(ctx context.Context, address string) (net.Conn, error) {
+		return proxy.Dial(ctx, address, 0)
+	})
+}
+
+// startNetwork connects to the network and adds it to the xdcc engine.
+func (d *daemon) startNetwork(cfg config.Config, network config.Network) error {
+	options := dialOptions(cfg, network)
+	s := &supervisor.Supervisor{
+		Dial: func(ctx context.Context) (io.ReadWriteCloser, error) {
+			return transport.Dial(ctx, network.Server, options)
+		},
+		NewEngine: func() *irc.Engine {
+			return &irc.Engine{
+				NickLength:        cfg.Identity.NickLength,
+				Nicks:             network.Nicks,
+				Version:           cfg.Identity.Version,
+				Encoding:          network.Encoding,
+				Capabilities:      network.Capabilities,
+				FloodBurst:        cfg.Flood.Burst,
+				FloodIntervalMsec: cfg.Flood.IntervalMsec,
+				PingIntervalMsec:  cfg.Timeouts.PingIntervalMsec,
+				PingTimeoutMsec:   cfg.Timeouts.PingTimeoutMsec,
+				RejoinMinMsec:     cfg.Timeouts.RejoinMinMsec,
+				RejoinMaxMsec:     cfg.Timeouts.RejoinMaxMsec,
+				PresencePollMsec:  cfg.Timeouts.PresencePollMsec,
+				Auth: irc.Auth{
+					SASLMechanism: network.Auth.SASLMechanism,
+					Account:       network.Auth.Account,
+					Password:      network.Auth.Password,
+					NickServ:      network.Auth.NickServ,
+				},
+			}
+		},
+		OnReconnect: func(engine *irc.Engine) {
+			d.xdccEngine.Restart(network.Name, engine)
+		},
+		RegisterTimeoutMsec: cfg.Timeouts.RegisterMsec,
+		MinBackoffMsec:      cfg.Timeouts.ReconnectMinMsec,
+		MaxBackoffMsec:      cfg.Timeouts.ReconnectMaxMsec,
+	}
+
+	err := s.Start()
+	if err != nil {
+		return fmt.Errorf("%s: %v", network.Name, err)
+	}
+
+	d.networks = append(d.networks, network.Name)
+	d.supervisors[network.Name] = s
+	d.xdccEngine.AddNetwork(network.Name, s.Engine())
+
+	return nil
+}
+
+// StatusJSON writes JSON representation of all networks' statuses to given writer.
+func (d *daemon) StatusJSON(writer io.Writer) error {
+	status := api.Status{Networks: make([]api.NetworkStatus, 0, len(d.networks))}
+	for _, name := range d.networks {
+		status.Networks = append(status.Networks, api.NetworkStatus{Name: name, Status: d.supervisors[name].Status()})
+	}
+
+	return json.NewEncoder(writer).Encode(status)
+}
+
+// Addr returns address the API is served on.
+func (d *daemon) Addr() net.Addr {
+	return d.listener.Addr()
+}
+
+// Nick returns nick the daemon is currently registered with on given network.
+func (d *daemon) Nick(networkName string) string {
+	return d.supervisors[networkName].Engine().Nick()
+}
+
+// Shutdown stops the daemon gracefully. It stops serving the API, quits IRC,
+// waits for running transfers until given context gets canceled and saves the state.
+func (d *daemon) Shutdown(ctx context.Context) error {
+	d.httpServer.Shutdown(ctx)
+	drainPromise := d.xdccEngine.Shutdown(ctx)
+
+	quitWaitGroup := &sync.WaitGroup{}
+	for _, s := range d.supervisors {
+		quitWaitGroup.Add(1)
+		go func(s *supervisor.Supervisor) {
+			defer quitWaitGroup.Done()
+			s.Quit("Shutting down")
+		}(s)
+	}
+	quitWaitGroup.Wait()
+
+	<-drainPromise
+
+	return d.saveState()
+}
+
+// loadState restores downloads saved by previous run, if there are any.
+func (d *daemon) loadState() error {
+	if d.stateFile == "" {
+		return nil
+	}
+
+	file, err := os.Open(d.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return d.xdccEngine.LoadDownloads(file)
+}
+
+// saveState atomically replaces the state file with current downloads.
+func (d *daemon) saveState() error {
+	if d.stateFile == "" {
+		return nil
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(d.stateFile), ".animuxd-state")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	err = d.xdccEngine.DownloadsJSON(file)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(file.Name(), d.stateFile)
+}
+
+// Stop closes the API server and all engines immediately.
+func (d *daemon) Stop() {
+	if d.httpServer != nil {
+		d.httpServer.Close()
+	}
+	d.xdccEngine.Stop()
+	for _, s := range d.supervisors {
+		s.Stop()
+	}
+}
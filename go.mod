@@ -4,6 +4,7 @@ go 1.14
 
 require (
 	github.com/julienschmidt/httprouter v1.3.0
-	github.com/stretchr/testify v1.5.1
 	github.com/rs/cors v1.7.0
+	github.com/stretchr/testify v1.5.1
+	gopkg.in/yaml.v2 v2.4.0
 )
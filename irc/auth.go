@@ -0,0 +1,135 @@
+package irc
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+const saslChunkLength = 400
+
+// Auth describes how the engine authenticates to services.
+type Auth struct {
+	// SASLMechanism is either PLAIN or EXTERNAL. Empty disables SASL.
+	SASLMechanism string
+	Account       string
+	Password      string
+	// NickServ enables identifying with NickServ when SASL is disabled or fails.
+	NickServ bool
+}
+
+// AuthStatus describes the result of authentication.
+type AuthStatus int
+
+const (
+	AuthNone AuthStatus = iota
+	AuthPending
+	AuthSASL
+	AuthNickServ
+	AuthFailed
+)
+
+func (s AuthStatus) String() string {
+	switch s {
+	case AuthPending:
+		return "pending"
+	case AuthSASL:
+		return "sasl"
+	case AuthNickServ:
+		return "nickserv"
+	case AuthFailed:
+		return "failed"
+	default:
+		return "none"
+	}
+}
+
+// AuthStatus returns the result of authentication performed during Register.
+func (e *Engine) AuthStatus() AuthStatus {
+	e.authMutex.RLock()
+	defer e.authMutex.RUnlock()
+
+	return e.authStatus
+}
+
+func (e *Engine) setAuthStatus(status AuthStatus) {
+	e.authMutex.Lock()
+	defer e.authMutex.Unlock()
+
+	e.authStatus = status
+}
+
+// authenticateSASL authenticates with the mechanism set in Auth.
+// The sasl capability must be enabled beforehand.
+func (e *Engine) authenticateSASL(next nextPacket) {
+	e.send(fmt.Sprintf("AUTHENTICATE %s", e.Auth.SASLMechanism))
+	for ready := false; !ready; {
+		packet, ok := next()
+		if !ok || packet.Type == ErrSaslFail {
+			e.setAuthStatus(AuthFailed)
+			return
+		}
+		ready = packet.Type == Authenticate && packet.Payload == "+"
+	}
+
+	if e.Auth.SASLMechanism == "PLAIN" {
+		for _, message := range saslPlainMessages(e.Auth.Account, e.Auth.Password) {
+			e.send(fmt.Sprintf("AUTHENTICATE %s", message))
+		}
+	} else {
+		e.send("AUTHENTICATE +")
+	}
+
+	for {
+		packet, ok := next()
+		if !ok || packet.Type == ErrSaslFail {
+			e.setAuthStatus(AuthFailed)
+			return
+		}
+		if packet.Type == RplSaslSuccess {
+			e.setAuthStatus(AuthSASL)
+			return
+		}
+	}
+}
+
+// identify sends credentials to NickServ and waits for the logged in reply.
+func (e *Engine) identify(ctx context.Context, timeout time.Duration) {
+	packets, unsubscribe := e.Subscribe(OfType(RplLoggedIn))
+	defer unsubscribe()
+
+	credentials := e.Auth.Password
+	if e.Auth.Account != "" {
+		credentials = fmt.Sprintf("%s %s", e.Auth.Account, e.Auth.Password)
+	}
+	e.SendMessage("NickServ", fmt.Sprintf("IDENTIFY %s", credentials))
+
+	select {
+	case <-packets:
+		e.setAuthStatus(AuthNickServ)
+	case <-ctx.Done():
+		e.setAuthStatus(AuthFailed)
+	case <-time.After(timeout):
+		e.setAuthStatus(AuthFailed)
+	}
+}
+
+// saslPlainMessages encodes credentials and splits them into AUTHENTICATE sized chunks.
+// A message of exactly saslChunkLength is followed by "+" to mark the end.
+func saslPlainMessages(account string, password string) []string {
+	encoded := base64.StdEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%s\x00%s\x00%s", account, account, password)),
+	)
+
+	messages := []string{}
+	for len(encoded) >= saslChunkLength {
+		messages = append(messages, encoded[:saslChunkLength])
+		encoded = encoded[saslChunkLength:]
+	}
+	if encoded == "" {
+		encoded = "+"
+	}
+
+	return append(messages, encoded)
+}
@@ -0,0 +1,174 @@
+package irc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readLine(reader *bufio.Reader) string {
+	line, _ := reader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+func TestRegisterWithSaslPlain(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{Nicks: []string{"ownadi"}, Auth: Auth{SASLMechanism: "PLAIN", Account: "acc", Password: "secret"}}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 999999)
+
+	assert.Equal(t, "CAP LS 302", readLine(reader))
+	assert.Equal(t, "USER ownadi * * ownadi", readLine(reader))
+	assert.Equal(t, "NICK ownadi", readLine(reader))
+
+	client.Write([]byte(":irc.rizon.club CAP * LS * :multi-prefix\r\n"))
+	client.Write([]byte(":irc.rizon.club CAP * LS :sasl=PLAIN,EXTERNAL\r\n"))
+	assert.Equal(t, "CAP REQ :sasl", readLine(reader))
+	client.Write([]byte(":irc.rizon.club CAP * ACK :sasl\r\n"))
+	assert.Equal(t, "AUTHENTICATE PLAIN", readLine(reader))
+	assert.Equal(t, AuthPending, engine.AuthStatus())
+	client.Write([]byte("AUTHENTICATE +\r\n"))
+
+	credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(readLine(reader), "AUTHENTICATE "))
+	assert.Equal(t, "acc\x00acc\x00secret", string(credentials))
+
+	client.Write([]byte(":irc.rizon.club 900 ownadi ownadi!u@h acc :You are now logged in as acc\r\n"))
+	client.Write([]byte(":irc.rizon.club 903 ownadi :SASL authentication successful\r\n"))
+	assert.Equal(t, "CAP END", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+
+	assert.True(t, <-registerPromise)
+	assert.Equal(t, AuthSASL, engine.AuthStatus())
+}
+
+func TestRegisterWithSaslExternalFailure(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{Nicks: []string{"ownadi"}, Auth: Auth{SASLMechanism: "EXTERNAL"}}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 999999)
+
+	readLine(reader)
+	readLine(reader)
+	readLine(reader)
+	client.Write([]byte(":irc.rizon.club CAP * LS :sasl\r\n"))
+	readLine(reader)
+	client.Write([]byte(":irc.rizon.club CAP * ACK :sasl\r\n"))
+	assert.Equal(t, "AUTHENTICATE EXTERNAL", readLine(reader))
+	client.Write([]byte("AUTHENTICATE +\r\n"))
+	assert.Equal(t, "AUTHENTICATE +", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 904 ownadi :SASL authentication failed\r\n"))
+	assert.Equal(t, "CAP END", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+
+	assert.True(t, <-registerPromise)
+	assert.Equal(t, AuthFailed, engine.AuthStatus())
+}
+
+func TestRegisterWaitsForSlowSasl(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{Nicks: []string{"ownadi"}, Auth: Auth{SASLMechanism: "EXTERNAL"}}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 100)
+
+	readLine(reader)
+	readLine(reader)
+	readLine(reader)
+	time.Sleep(60 * time.Millisecond)
+	client.Write([]byte(":irc.rizon.club CAP * LS :sasl\r\n"))
+	readLine(reader)
+	time.Sleep(60 * time.Millisecond)
+	client.Write([]byte(":irc.rizon.club CAP * ACK :sasl\r\n"))
+	assert.Equal(t, "AUTHENTICATE EXTERNAL", readLine(reader))
+	time.Sleep(60 * time.Millisecond)
+	client.Write([]byte("AUTHENTICATE +\r\n"))
+	assert.Equal(t, "AUTHENTICATE +", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 903 ownadi :SASL authentication successful\r\n"))
+	assert.Equal(t, "CAP END", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+
+	assert.True(t, <-registerPromise)
+	assert.Equal(t, "ownadi", engine.Nick())
+}
+
+func TestRegisterFallsBackToNickServ(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{
+		Nicks: []string{"ownadi"},
+		Auth:  Auth{SASLMechanism: "PLAIN", Account: "acc", Password: "secret", NickServ: true},
+	}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 999999)
+
+	readLine(reader)
+	readLine(reader)
+	readLine(reader)
+	client.Write([]byte(":irc.rizon.club CAP * LS :multi-prefix\r\n"))
+	assert.Equal(t, "CAP END", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+	assert.Equal(t, "PRIVMSG NickServ :IDENTIFY acc secret", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 900 ownadi ownadi!u@h acc :You are now logged in as acc\r\n"))
+
+	assert.True(t, <-registerPromise)
+	assert.Equal(t, AuthNickServ, engine.AuthStatus())
+}
+
+func TestRegisterWithoutAuth(t *testing.T) {
+	_, server := net.Pipe()
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	assert.Equal(t, AuthNone, engine.AuthStatus())
+	assert.Equal(t, "none", engine.AuthStatus().String())
+}
+
+func TestRegisterTriesConfiguredNicks(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{Nicks: []string{"foo", "bar"}}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 999999)
+
+	readLine(reader)
+	assert.Equal(t, "NICK foo", readLine(reader))
+	client.Write([]byte(":magnet.rizon.net 433 * foo :Nickname is already in use.\r\n"))
+	readLine(reader)
+	assert.Equal(t, "NICK bar", readLine(reader))
+	client.Write([]byte(":magnet.rizon.net 433 * bar :Nickname is already in use.\r\n"))
+	readLine(reader)
+	randomNick := nickPattern.FindStringSubmatch(readLine(reader))[1]
+	client.Write([]byte(fmt.Sprintf(":irc.rizon.club 001 %s :Welcome\r\n", randomNick)))
+
+	assert.True(t, <-registerPromise)
+	assert.Len(t, engine.Nick(), nickLength)
+}
+
+func TestSaslPlainMessages(t *testing.T) {
+	assert.Equal(t, []string{"YQBhAGI="}, saslPlainMessages("a", "b"))
+
+	password := strings.Repeat("x", 296)
+	messages := saslPlainMessages("a", password)
+	assert.Len(t, messages, 2)
+	assert.Len(t, messages[0], 400)
+	assert.Equal(t, "+", messages[1])
+}
@@ -0,0 +1,142 @@
+package irc
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// nextPacket returns next packet received during negotiation or false when waiting for it failed.
+type nextPacket func() (Packet, bool)
+
+// EnabledCapabilities returns capabilities acknowledged by the server during Register.
+func (e *Engine) EnabledCapabilities() []string {
+	e.capabilitiesMutex.RLock()
+	defer e.capabilitiesMutex.RUnlock()
+
+	return append([]string{}, e.capabilities...)
+}
+
+// HasCapability tells whether given capability was acknowledged by the server.
+func (e *Engine) HasCapability(capability string) bool {
+	for _, enabled := range e.EnabledCapabilities() {
+		if enabled == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiate starts capability negotiation, requests wanted capabilities the server offers
+// and, when SASL is configured, authenticates. Each step waits for the server at most stepTimeout.
+// The returned channel gets closed when negotiation is over.
+func (e *Engine) negotiate(ctx context.Context, stepTimeout time.Duration) <-chan bool {
+	r := make(chan bool)
+	packets, unsubscribe := e.Subscribe(OfType(Cap, Authenticate, RplSaslSuccess, ErrSaslFail))
+
+	e.send("CAP LS 302")
+
+	go func() {
+		defer close(r)
+		defer unsubscribe()
+
+		next := func() (Packet, bool) {
+			select {
+			case packet := <-packets:
+				return packet, true
+			case <-ctx.Done():
+			case <-time.After(stepTimeout):
+			}
+			return Packet{}, false
+		}
+
+		e.negotiateSteps(next)
+	}()
+
+	return r
+}
+
+func (e *Engine) negotiateSteps(next nextPacket) {
+	sasl := e.Auth.SASLMechanism != ""
+
+	offered, ok := listCapabilities(next)
+	if !ok {
+		if sasl {
+			e.setAuthStatus(AuthFailed)
+		}
+		return
+	}
+	defer e.send("CAP END")
+
+	wanted := append([]string{}, e.Capabilities...)
+	if sasl {
+		wanted = append(wanted, "sasl")
+	}
+	requested := []string{}
+	for _, capability := range wanted {
+		if offered[capability] && !contains(requested, capability) {
+			requested = append(requested, capability)
+		}
+	}
+
+	if len(requested) > 0 {
+		e.send("CAP REQ :" + strings.Join(requested, " "))
+		acknowledged := false
+		for answered := false; !answered; {
+			packet, ok := next()
+			payload, _ := packet.Payload.(CapPayload)
+			answered = !ok || payload.Subcommand == "ACK" || payload.Subcommand == "NAK"
+			acknowledged = ok && payload.Subcommand == "ACK"
+		}
+
+		if acknowledged {
+			e.capabilitiesMutex.Lock()
+			e.capabilities = requested
+			e.capabilitiesMutex.Unlock()
+		}
+	}
+
+	if !sasl {
+		return
+	}
+	if !e.HasCapability("sasl") {
+		e.setAuthStatus(AuthFailed)
+		return
+	}
+	e.authenticateSASL(next)
+}
+
+// listCapabilities collects capabilities offered in, possibly multiline, reply to CAP LS.
+// Values, e.g. mechanisms of sasl=PLAIN,EXTERNAL, are dropped.
+func listCapabilities(next nextPacket) (map[string]bool, bool) {
+	offered := map[string]bool{}
+
+	for listed := false; !listed; {
+		packet, ok := next()
+		if !ok {
+			return offered, false
+		}
+
+		payload, payloadOk := packet.Payload.(CapPayload)
+		if !payloadOk || payload.Subcommand != "LS" {
+			continue
+		}
+		for _, capability := range payload.Capabilities {
+			offered[strings.SplitN(capability, "=", 2)[0]] = true
+		}
+		listed = !payload.More
+	}
+
+	return offered, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterRequestsOfferedCapabilities(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{Nicks: []string{"ownadi"}, Capabilities: []string{"server-time", "account-tag", "batch"}}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 999999)
+
+	assert.Equal(t, "CAP LS 302", readLine(reader))
+	readLine(reader)
+	readLine(reader)
+	client.Write([]byte(":irc.rizon.club CAP * LS :multi-prefix server-time account-tag sasl\r\n"))
+	assert.Equal(t, "CAP REQ :server-time account-tag", readLine(reader))
+	client.Write([]byte(":irc.rizon.club CAP * ACK :server-time account-tag\r\n"))
+	assert.Equal(t, "CAP END", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+
+	assert.True(t, <-registerPromise)
+	assert.Equal(t, []string{"server-time", "account-tag"}, engine.EnabledCapabilities())
+	assert.True(t, engine.HasCapability("server-time"))
+	assert.False(t, engine.HasCapability("batch"))
+	assert.Equal(t, AuthNone, engine.AuthStatus())
+}
+
+func TestRegisterWithMultilineCapabilities(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{Nicks: []string{"ownadi"}, Capabilities: []string{"server-time", "batch"}}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 999999)
+
+	readLine(reader)
+	readLine(reader)
+	readLine(reader)
+	client.Write([]byte(
+		":irc.rizon.club CAP * LS * :server-time sasl\r\n" +
+			":irc.rizon.club CAP * LS :batch multi-prefix\r\n",
+	))
+	assert.Equal(t, "CAP REQ :server-time batch", readLine(reader))
+	client.Write([]byte(":irc.rizon.club CAP * ACK :server-time batch\r\n"))
+	assert.Equal(t, "CAP END", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+
+	assert.True(t, <-registerPromise)
+	assert.Equal(t, []string{"server-time", "batch"}, engine.EnabledCapabilities())
+}
+
+func TestRegisterWithRejectedCapabilities(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{Nicks: []string{"ownadi"}, Capabilities: []string{"server-time"}}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 999999)
+
+	readLine(reader)
+	readLine(reader)
+	readLine(reader)
+	client.Write([]byte(":irc.rizon.club CAP * LS :server-time\r\n"))
+	assert.Equal(t, "CAP REQ :server-time", readLine(reader))
+	client.Write([]byte(":irc.rizon.club CAP * NAK :server-time\r\n"))
+	assert.Equal(t, "CAP END", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+
+	assert.True(t, <-registerPromise)
+	assert.Empty(t, engine.EnabledCapabilities())
+}
+
+func TestRegisterWithoutOfferedCapabilities(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{Nicks: []string{"ownadi"}, Capabilities: []string{"server-time", "batch"}}
+	engine.Start(server)
+	defer engine.Stop()
+	registerPromise := engine.Register(engine.Context(), 999999)
+
+	readLine(reader)
+	readLine(reader)
+	readLine(reader)
+	client.Write([]byte(":irc.rizon.club CAP * LS :multi-prefix\r\n"))
+	assert.Equal(t, "CAP END", readLine(reader))
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+
+	assert.True(t, <-registerPromise)
+	assert.Empty(t, engine.EnabledCapabilities())
+}
@@ -0,0 +1,182 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const rejoinTimeoutMsec = 30000
+const rejoinMaxMsec = 300000
+
+// channels tracks channels we are on and ones we want to stay on, keyed by lower-cased name with hash.
+type channels struct {
+	// joined maps keys to names as the server spells them.
+	joined map[string]string
+	// wanted maps channels joined with Join and not parted yet to the number of kicks from them.
+	wanted map[string]int
+}
+
+// Channels returns channels the engine is currently on, sorted.
+func (e *Engine) Channels() []string {
+	e.channelsMutex.RLock()
+	defer e.channelsMutex.RUnlock()
+
+	names := make([]string, 0, len(e.channels.joined))
+	for _, name := range e.channels.joined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Part leaves the channel. It is not rejoined anymore after kicks.
+func (e *Engine) Part(channelName string) {
+	channelWithHash := withHash(channelName)
+	key := strings.ToLower(channelWithHash)
+
+	e.channelsMutex.Lock()
+	delete(e.channels.wanted, key)
+	_, joined := e.channels.joined[key]
+	delete(e.channels.joined, key)
+	e.channelsMutex.Unlock()
+
+	if joined {
+		e.send(fmt.Sprintf("PART %s", channelWithHash))
+	}
+}
+
+// isOn tells whether we are on the channel and marks it as wanted if we are.
+func (e *Engine) isOn(channelWithHash string) bool {
+	key := strings.ToLower(channelWithHash)
+
+	e.channelsMutex.Lock()
+	defer e.channelsMutex.Unlock()
+
+	_, joined := e.channels.joined[key]
+	if joined {
+		e.channels.wanted[key] = e.channels.wanted[key]
+	}
+
+	return joined
+}
+
+func (e *Engine) setJoined(channelWithHash string) {
+	e.channelsMutex.Lock()
+	e.channels.joined[strings.ToLower(channelWithHash)] = channelWithHash
+	e.channelsMutex.Unlock()
+}
+
+// want marks the channel as one to rejoin after kicks, keeping the number of kicks.
+func (e *Engine) want(channelWithHash string) {
+	key := strings.ToLower(channelWithHash)
+
+	e.channelsMutex.Lock()
+	e.channels.wanted[key] = e.channels.wanted[key]
+	e.channelsMutex.Unlock()
+}
+
+// trackChannels updates joined channels on our JOIN, PART and KICK as well as
+// RPL_NAMREPLY listing us. Kicks from wanted channels are followed by rejoins.
+func (e *Engine) trackChannels(packet Packet) {
+	m := packet.Message
+	nick := e.Nick()
+	if nick == "" {
+		return
+	}
+
+	switch strings.ToUpper(m.Command) {
+	case "JOIN":
+		if strings.EqualFold(m.Prefix.Name, nick) && m.ParamsCount() > 0 {
+			e.setJoined(m.Param(0))
+		}
+	case "PART":
+		if strings.EqualFold(m.Prefix.Name, nick) && m.ParamsCount() > 0 {
+			e.channelsMutex.Lock()
+			delete(e.channels.joined, strings.ToLower(m.Param(0)))
+			e.channelsMutex.Unlock()
+		}
+	case "KICK":
+		if m.ParamsCount() > 1 && strings.EqualFold(m.Param(1), nick) {
+			e.kicked(m.Param(0))
+		}
+	case rplNamReply:
+		if m.ParamsCount() < 4 {
+			return
+		}
+		for _, name := range strings.Fields(m.Param(3)) {
+			if strings.EqualFold(strings.TrimLeft(name, "~&@%+"), nick) {
+				e.setJoined(m.Param(2))
+			}
+		}
+	}
+}
+
+// kicked forgets the channel and schedules a rejoin, with delay doubled for each kick.
+func (e *Engine) kicked(channelWithHash string) {
+	key := strings.ToLower(channelWithHash)
+
+	e.channelsMutex.Lock()
+	delete(e.channels.joined, key)
+	kicks, wanted := e.channels.wanted[key]
+	if wanted {
+		e.channels.wanted[key] = kicks + 1
+	}
+	e.channelsMutex.Unlock()
+
+	if !wanted || e.RejoinMinMsec <= 0 {
+		return
+	}
+
+	go func() {
+		select {
+		case <-time.After(e.rejoinDelay(kicks)):
+		case <-e.ctx.Done():
+			return
+		}
+
+		e.channelsMutex.RLock()
+		_, wanted := e.channels.wanted[key]
+		e.channelsMutex.RUnlock()
+		if !wanted {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(e.ctx, rejoinTimeoutMsec*time.Millisecond)
+		defer cancel()
+		if err := <-e.Join(ctx, channelWithHash); err != nil {
+			e.channelsMutex.Lock()
+			delete(e.channels.wanted, key)
+			e.channelsMutex.Unlock()
+		}
+	}()
+}
+
+// rejoinDelay grows exponentially from RejoinMinMsec to RejoinMaxMsec.
+func (e *Engine) rejoinDelay(kicks int) time.Duration {
+	maxDelay := e.RejoinMaxMsec
+	if maxDelay <= 0 {
+		maxDelay = rejoinMaxMsec
+	}
+
+	delay := e.RejoinMinMsec
+	for i := 0; i < kicks && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return time.Duration(delay) * time.Millisecond
+}
+
+func withHash(channelName string) string {
+	if strings.HasPrefix(channelName, "#") {
+		return channelName
+	}
+
+	return fmt.Sprintf("#%s", channelName)
+}
@@ -0,0 +1,109 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startRegistered(engine *Engine) (net.Conn, *bufio.Scanner) {
+	client, server := net.Pipe()
+	engine.Start(server)
+	engine.setNick("ownadi")
+
+	return client, bufio.NewScanner(client)
+}
+
+func join(t *testing.T, engine *Engine, client net.Conn, scanner *bufio.Scanner, channelName string) {
+	promise := engine.Join(engine.Context(), channelName)
+
+	scanner.Scan()
+	assert.Equal(t, "JOIN #"+channelName, scanner.Text())
+	client.Write([]byte(":ownadi!~o@h JOIN #" + channelName + "\r\n"))
+	client.Write([]byte(":irc.rizon.club 366 ownadi #" + channelName + " :End of /NAMES list.\r\n"))
+
+	assert.Nil(t, <-promise)
+}
+
+func TestJoinSkipsJoinedChannels(t *testing.T) {
+	engine := &Engine{}
+	client, scanner := startRegistered(engine)
+	defer engine.Stop()
+
+	join(t, engine, client, scanner, "foo")
+	assert.Equal(t, []string{"#foo"}, engine.Channels())
+
+	assert.Nil(t, <-engine.Join(engine.Context(), "#FOO"))
+	engine.SendMessage("Gintoki", "bar")
+
+	scanner.Scan()
+	assert.Equal(t, "PRIVMSG Gintoki :bar", scanner.Text())
+}
+
+func TestPart(t *testing.T) {
+	engine := &Engine{}
+	client, scanner := startRegistered(engine)
+	defer engine.Stop()
+
+	join(t, engine, client, scanner, "foo")
+	engine.Part("foo")
+
+	scanner.Scan()
+	assert.Equal(t, "PART #foo", scanner.Text())
+	assert.Empty(t, engine.Channels())
+}
+
+func TestChannelsTracksPartAndNames(t *testing.T) {
+	engine := &Engine{}
+	client, _ := startRegistered(engine)
+	defer engine.Stop()
+
+	client.Write([]byte(":irc.rizon.club 353 ownadi = #foo :Gintoki @ownadi\r\n"))
+	client.Write([]byte(":irc.rizon.club 353 ownadi = #bar :Gintoki Kagura\r\n"))
+	assert.Eventually(t, func() bool { return len(engine.Channels()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"#foo"}, engine.Channels())
+
+	client.Write([]byte(":ownadi!~o@h PART #foo :bye\r\n"))
+	assert.Eventually(t, func() bool { return len(engine.Channels()) == 0 }, time.Second, time.Millisecond)
+}
+
+func TestRejoinAfterKick(t *testing.T) {
+	engine := &Engine{RejoinMinMsec: 10}
+	client, scanner := startRegistered(engine)
+	defer engine.Stop()
+
+	join(t, engine, client, scanner, "foo")
+	client.Write([]byte(":Gintoki!~g@h KICK #foo ownadi :out\r\n"))
+
+	scanner.Scan()
+	assert.Equal(t, "JOIN #foo", scanner.Text())
+	assert.Empty(t, engine.Channels())
+}
+
+func TestNoRejoinAfterPart(t *testing.T) {
+	engine := &Engine{RejoinMinMsec: 10}
+	client, scanner := startRegistered(engine)
+	defer engine.Stop()
+
+	join(t, engine, client, scanner, "foo")
+	engine.Part("foo")
+	scanner.Scan()
+	client.Write([]byte(":Gintoki!~g@h KICK #foo ownadi :out\r\n"))
+
+	time.Sleep(50 * time.Millisecond)
+	engine.SendMessage("Gintoki", "bar")
+
+	scanner.Scan()
+	assert.Equal(t, "PRIVMSG Gintoki :bar", scanner.Text())
+}
+
+func TestRejoinDelay(t *testing.T) {
+	engine := &Engine{RejoinMinMsec: 1000, RejoinMaxMsec: 5000}
+
+	assert.Equal(t, time.Second, engine.rejoinDelay(0))
+	assert.Equal(t, 4*time.Second, engine.rejoinDelay(2))
+	assert.Equal(t, 5*time.Second, engine.rejoinDelay(10))
+}
@@ -0,0 +1,108 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultVersion is sent in reply to CTCP VERSION when Engine.Version is empty.
+const DefaultVersion = "animuxd"
+
+const ctcpDelimiter = "\x01"
+
+// CTCPPayload is a CTCP query, e.g. \x01PING 123\x01, sent to us in PRIVMSG.
+type CTCPPayload struct {
+	Nick    string
+	Command string
+	Params  string
+}
+
+// A CTCPHandler answers a CTCP query. Returned params are sent back in NOTICE
+// unless the handler returns false.
+type CTCPHandler func(e *Engine, query CTCPPayload) (string, bool)
+
+// parseCTCP extracts CTCP command and params out of \x01 delimited text.
+// Closing delimiter is optional as some clients omit it.
+func parseCTCP(text string) (string, string, bool) {
+	if !strings.HasPrefix(text, ctcpDelimiter) {
+		return "", "", false
+	}
+
+	text = strings.TrimSuffix(text[1:], ctcpDelimiter)
+	command, params := cutWord(text)
+	if command == "" {
+		return "", "", false
+	}
+
+	return strings.ToUpper(command), params, true
+}
+
+// defaultCTCPHandlers answer standard queries. CLIENTINFO is handled by the engine
+// itself as it lists all known commands.
+var defaultCTCPHandlers = map[string]CTCPHandler{
+	"VERSION": func(e *Engine, query CTCPPayload) (string, bool) {
+		if e.Version != "" {
+			return e.Version, true
+		}
+		return DefaultVersion, true
+	},
+	"PING": func(e *Engine, query CTCPPayload) (string, bool) {
+		return query.Params, true
+	},
+	"TIME": func(e *Engine, query CTCPPayload) (string, bool) {
+		return time.Now().Format(time.RFC1123Z), true
+	},
+}
+
+// ctcpHandler returns handler of given command, preferring the ones in CTCPHandlers.
+func (e *Engine) ctcpHandler(command string) (CTCPHandler, bool) {
+	if handler, ok := e.CTCPHandlers[command]; ok {
+		return handler, handler != nil
+	}
+	if command == "CLIENTINFO" {
+		return func(e *Engine, query CTCPPayload) (string, bool) {
+			return strings.Join(e.ctcpCommands(), " "), true
+		}, true
+	}
+
+	handler, ok := defaultCTCPHandlers[command]
+	return handler, ok
+}
+
+// ctcpCommands lists commands we answer, sorted.
+func (e *Engine) ctcpCommands() []string {
+	commands := []string{"CLIENTINFO"}
+	for command := range defaultCTCPHandlers {
+		if _, overridden := e.CTCPHandlers[command]; !overridden {
+			commands = append(commands, command)
+		}
+	}
+	for command, handler := range e.CTCPHandlers {
+		if handler != nil && command != "CLIENTINFO" {
+			commands = append(commands, command)
+		}
+	}
+	sort.Strings(commands)
+
+	return commands
+}
+
+// handleCTCP answers the query with NOTICE. Unknown queries are ignored.
+func (e *Engine) handleCTCP(query CTCPPayload) {
+	handler, ok := e.ctcpHandler(query.Command)
+	if !ok || query.Nick == "" {
+		return
+	}
+
+	params, reply := handler(e, query)
+	if !reply {
+		return
+	}
+
+	body := query.Command
+	if params != "" {
+		body += " " + params
+	}
+	e.send("NOTICE " + query.Nick + " :" + ctcpDelimiter + body + ctcpDelimiter)
+}
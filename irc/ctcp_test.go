@@ -0,0 +1,93 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCTCPVersion(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{Version: "animuxd 1.2"}
+	engine.Start(server)
+	defer engine.Stop()
+
+	client.Write([]byte(":Gintoki!~Gin@h PRIVMSG ownadi :\x01VERSION\x01\r\n"))
+	scanner.Scan()
+
+	assert.Equal(t, "NOTICE Gintoki :\x01VERSION animuxd 1.2\x01", scanner.Text())
+}
+
+func TestCTCPDefaultVersion(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	client.Write([]byte(":Gintoki!~Gin@h PRIVMSG #foo :\x01version\r\n"))
+	scanner.Scan()
+
+	assert.Equal(t, "NOTICE Gintoki :\x01VERSION "+DefaultVersion+"\x01", scanner.Text())
+}
+
+func TestCTCPPing(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	client.Write([]byte(":Gintoki!~Gin@h PRIVMSG ownadi :\x01PING 1588336200 123\x01\r\n"))
+	scanner.Scan()
+
+	assert.Equal(t, "NOTICE Gintoki :\x01PING 1588336200 123\x01", scanner.Text())
+}
+
+func TestCTCPTime(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	client.Write([]byte(":Gintoki!~Gin@h PRIVMSG ownadi :\x01TIME\x01\r\n"))
+	scanner.Scan()
+
+	reply := scanner.Text()
+	assert.Regexp(t, "^NOTICE Gintoki :\x01TIME .+\x01$", reply)
+	_, err := time.Parse(time.RFC1123Z, reply[len("NOTICE Gintoki :\x01TIME "):len(reply)-1])
+	assert.Nil(t, err)
+}
+
+func TestCTCPHandlers(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{CTCPHandlers: map[string]CTCPHandler{
+		"SOURCE": func(e *Engine, query CTCPPayload) (string, bool) {
+			return "https://github.com/ownadi/animuxd", true
+		},
+		"TIME": nil,
+	}}
+	engine.Start(server)
+	defer engine.Stop()
+
+	client.Write([]byte(":Gintoki!~Gin@h PRIVMSG ownadi :\x01SOURCE\x01\r\n"))
+	scanner.Scan()
+	assert.Equal(t, "NOTICE Gintoki :\x01SOURCE https://github.com/ownadi/animuxd\x01", scanner.Text())
+
+	client.Write([]byte(":Gintoki!~Gin@h PRIVMSG ownadi :\x01TIME\x01\r\n"))
+	client.Write([]byte(":Gintoki!~Gin@h PRIVMSG ownadi :\x01FINGER\x01\r\n"))
+	client.Write([]byte(":Gintoki!~Gin@h PRIVMSG ownadi :\x01CLIENTINFO\x01\r\n"))
+	scanner.Scan()
+	assert.Equal(t, "NOTICE Gintoki :\x01CLIENTINFO CLIENTINFO PING SOURCE VERSION\x01", scanner.Text())
+}
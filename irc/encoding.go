@@ -0,0 +1,87 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Encodings used to decode lines which are not valid UTF-8.
+const (
+	// EncodingCP1252 is the default. It is what most Windows clients send.
+	EncodingCP1252 = "cp1252"
+	EncodingLatin1 = "latin1"
+	// EncodingUTF8 disables the fallback. Invalid bytes are replaced with U+FFFD.
+	EncodingUTF8 = "utf-8"
+)
+
+// encodingAliases maps accepted names of encodings to the canonical ones.
+var encodingAliases = map[string]string{
+	"":             EncodingCP1252,
+	"cp1252":       EncodingCP1252,
+	"windows-1252": EncodingCP1252,
+	"latin1":       EncodingLatin1,
+	"latin-1":      EncodingLatin1,
+	"iso-8859-1":   EncodingLatin1,
+	"utf-8":        EncodingUTF8,
+	"utf8":         EncodingUTF8,
+}
+
+// cp1252 maps bytes 0x80-0x9F which differ from Latin-1. Undefined ones stay as Latin-1 controls.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// ValidEncoding tells whether the name of encoding is known. Names are case-insensitive.
+func ValidEncoding(name string) bool {
+	_, ok := encodingAliases[strings.ToLower(name)]
+	return ok
+}
+
+// decodeLine returns valid UTF-8 lines as they are and decodes other ones with given encoding.
+func decodeLine(line string, encoding string) string {
+	if utf8.ValidString(line) {
+		return line
+	}
+
+	switch encodingAliases[strings.ToLower(encoding)] {
+	case EncodingUTF8:
+		return strings.ToValidUTF8(line, string(utf8.RuneError))
+	case EncodingLatin1:
+		return decodeSingleByte(line, false)
+	default:
+		return decodeSingleByte(line, true)
+	}
+}
+
+// withRawFileName keeps the undecoded file name of DCC SEND offers received in decoded lines,
+// so that replies name the file with the bytes the bot knows.
+func withRawFileName(packet Packet, line string) Packet {
+	payload, ok := packet.Payload.(PrivMsgDccSendPayload)
+	if !ok || utf8.ValidString(line) {
+		return packet
+	}
+
+	if raw, rawOk := Parse(line).Payload.(PrivMsgDccSendPayload); rawOk && raw.FileName != payload.FileName {
+		payload.RawFileName = raw.FileName
+		packet.Payload = payload
+	}
+
+	return packet
+}
+
+func decodeSingleByte(line string, windows bool) string {
+	var b strings.Builder
+	b.Grow(len(line) * 2)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if windows && c >= 0x80 && c <= 0x9F {
+			b.WriteRune(cp1252[c-0x80])
+		} else {
+			b.WriteRune(rune(c))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package irc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		encoding string
+		decoded  string
+	}{
+		{"Café ‘Gin’", "", "Café ‘Gin’"},
+		{"Caf\xe9 \x91Gin\x92 \x80", "", "Café ‘Gin’ €"},
+		{"Caf\xe9 \x91Gin\x92 \x80", "Windows-1252", "Café ‘Gin’ €"},
+		{"Caf\xe9 \x91Gin\x92", "latin1", "Café \u0091Gin\u0092"},
+		{"Caf\xe9", "utf-8", "Caf�"},
+		{"\x81\x8d", "cp1252", "\u0081\u008d"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.decoded, decodeLine(test.line, test.encoding), test.line)
+	}
+}
+
+func TestValidEncoding(t *testing.T) {
+	for _, name := range []string{"", "CP1252", "windows-1252", "latin1", "ISO-8859-1", "utf-8", "UTF8"} {
+		assert.True(t, ValidEncoding(name), name)
+	}
+	assert.False(t, ValidEncoding("koi8-r"))
+}
+
+func TestEngineDecodesLines(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	engine := &Engine{Encoding: "latin1"}
+	engine.Start(server)
+	defer engine.Stop()
+
+	client.Write([]byte(":b0t!b@h PRIVMSG ownadi :\x01DCC SEND \"Caf\xe9 \\\"Gin\\\".mkv\" 2130706433 39095 339260\x01\r\n"))
+
+	packet := <-engine.IRCPacketsChann()
+
+	assert.Equal(t, PrivMsgDccSend, packet.Type)
+	assert.Equal(t, `Café "Gin".mkv`, packet.Payload.(PrivMsgDccSendPayload).FileName)
+	assert.Equal(t, "Caf\xe9 \"Gin\".mkv", packet.Payload.(PrivMsgDccSendPayload).OfferedFileName())
+}
@@ -14,12 +14,14 @@ import (
 
 const nickLength = 7
 
-type onPacketCallback func(Packet)
-
 type IRCEngine interface {
 	IRCPacketsChann() chan Packet
-	Join(ctx context.Context, channelName string) <-chan bool
-	ChannelsOfUser(ctx context.Context, nick string) chan []string
+	Join(ctx context.Context, channelName string) <-chan error
+	ChannelsOfUser(ctx context.Context, nick string) <-chan ChannelsResult
+	Flushed() <-chan struct{}
+	Part(channelName string)
+	Watch(nick string)
+	Unwatch(nick string)
 	SendMessage(nick string, body string)
 	Context() context.Context
 }
@@ -27,26 +29,70 @@ type IRCEngine interface {
 // An Engine represents that part of the app which is responsible
 // for handling low-level IRC protocol related stuff.
 type Engine struct {
-	nick                    string
-	ircStream               io.ReadWriteCloser
-	ircPacketsChan          chan Packet
-	onRplWelcome            map[string]onPacketCallback
-	onErrNicknameInUse      map[string]onPacketCallback
-	onRplEndOfNames         map[string]onPacketCallback
-	onRplWhoisChannels      map[string]onPacketCallback
-	onRplWelcomeMutex       *sync.RWMutex
-	onErrNicknameInUseMutex *sync.RWMutex
-	onRplEndOfNamesMutex    *sync.RWMutex
-	onRplWhoisChannelsMutex *sync.RWMutex
-	ctx                     context.Context
-	cancelFunc              context.CancelFunc
+	NickLength int
+	// Nicks are tried in order before falling back to random ones.
+	Nicks []string
+	Auth  Auth
+	// Encoding decodes received lines which are not valid UTF-8, EncodingCP1252 when empty.
+	Encoding string
+	// Version is sent in reply to CTCP VERSION, DefaultVersion when empty.
+	Version string
+	// CTCPHandlers answer additional CTCP queries, keyed by upper-cased command.
+	// They take precedence over the built-in VERSION, PING, TIME and CLIENTINFO handlers.
+	// A nil handler disables the built-in one.
+	CTCPHandlers map[string]CTCPHandler
+	// Capabilities are requested during Register when the server offers them.
+	Capabilities []string
+	// FloodBurst lines are sent at once, then one line per FloodIntervalMsec (2000 when zero).
+	// Zero burst disables the flood limit.
+	FloodBurst        int
+	FloodIntervalMsec int64
+	// PingIntervalMsec is how often the server is PINGed once registered. Zero disables keepalive.
+	// The engine stops when PONG does not come within PingTimeoutMsec, PingIntervalMsec when zero.
+	PingIntervalMsec int64
+	PingTimeoutMsec  int64
+	// RejoinMinMsec delays rejoining a channel we got kicked from. It doubles with each kick
+	// up to RejoinMaxMsec, 300000 when zero. Zero disables rejoining.
+	RejoinMinMsec int64
+	RejoinMaxMsec int64
+	// PresencePollMsec is how often ISON is polled for watched nicks when the server
+	// does not support MONITOR, 30000 when zero.
+	PresencePollMsec  int64
+	lag               time.Duration
+	lagMutex          *sync.RWMutex
+	capabilities      []string
+	capabilitiesMutex *sync.RWMutex
+	authStatus        AuthStatus
+	authMutex         *sync.RWMutex
+	nick              string
+	nickMutex         *sync.RWMutex
+	sendQueue         *sendQueue
+	ircStream         io.ReadWriteCloser
+	ircPacketsChan    chan Packet
+	ircPacketsMutex   *sync.RWMutex
+	subscriptions     *subscriptions
+	channels          channels
+	channelsMutex     *sync.RWMutex
+	presence          presence
+	presenceMutex     *sync.Mutex
+	ctx               context.Context
+	cancelFunc        context.CancelFunc
 }
 
 // Nick returns current registered nick.
 func (e *Engine) Nick() string {
+	e.nickMutex.RLock()
+	defer e.nickMutex.RUnlock()
+
 	return e.nick
 }
 
+func (e *Engine) setNick(nick string) {
+	e.nickMutex.Lock()
+	e.nick = nick
+	e.nickMutex.Unlock()
+}
+
 // IRCPacketsChann returns channel of packets.
 func (e *Engine) IRCPacketsChann() chan Packet {
 	return e.ircPacketsChan
@@ -56,77 +102,75 @@ func (e *Engine) IRCPacketsChann() chan Packet {
 func (e *Engine) Start(ircStream io.ReadWriteCloser) {
 	e.ircStream = ircStream
 	e.nick = ""
-	e.onErrNicknameInUse = map[string]onPacketCallback{}
-	e.onRplWelcome = map[string]onPacketCallback{}
-	e.onRplEndOfNames = map[string]onPacketCallback{}
-	e.onRplWhoisChannels = map[string]onPacketCallback{}
-	e.onErrNicknameInUseMutex = &sync.RWMutex{}
-	e.onRplWelcomeMutex = &sync.RWMutex{}
-	e.onRplEndOfNamesMutex = &sync.RWMutex{}
-	e.onRplWhoisChannelsMutex = &sync.RWMutex{}
+	e.nickMutex = &sync.RWMutex{}
+	e.subscriptions = newSubscriptions()
+	e.channels = channels{joined: map[string]string{}, wanted: map[string]int{}}
+	e.channelsMutex = &sync.RWMutex{}
+	e.presence = presence{watched: map[string]string{}, online: map[string]bool{}}
+	e.presenceMutex = &sync.Mutex{}
+	e.authStatus = AuthNone
+	e.authMutex = &sync.RWMutex{}
+	e.capabilities = []string{}
+	e.capabilitiesMutex = &sync.RWMutex{}
+	e.sendQueue = newSendQueue()
+	e.lag = 0
+	e.lagMutex = &sync.RWMutex{}
 	e.ctx, e.cancelFunc = context.WithCancel(context.Background())
 
 	ircScanner := bufio.NewScanner(e.ircStream)
-	r := make(chan Packet, runtime.NumCPU())
-	e.ircPacketsChan = r
+	e.ircPacketsChan = make(chan Packet, runtime.NumCPU())
+	e.ircPacketsMutex = &sync.RWMutex{}
 
 	go func() {
 		<-e.ctx.Done()
 		e.ircStream.Close()
+		e.ircPacketsMutex.Lock()
 		close(e.ircPacketsChan)
+		e.ircPacketsMutex.Unlock()
 	}()
 
+	go e.writeQueued()
+
 	go func() {
 		defer e.cancelFunc()
 
 		for ircScanner.Scan() {
-			ircLine := ircScanner.Text()
-
-			go func(line string) {
-				packet := Parse(line)
-
-				if packet.Type == RplWelcome {
-					e.onRplWelcomeMutex.RLock()
-					for _, callback := range e.onRplWelcome {
-						callback(packet)
-					}
-					e.onRplWelcomeMutex.RUnlock()
-				}
-
-				if packet.Type == ErrNicknameInUse {
-					e.onErrNicknameInUseMutex.RLock()
-					for _, callback := range e.onErrNicknameInUse {
-						callback(packet)
-					}
-					e.onErrNicknameInUseMutex.RUnlock()
+			line := ircScanner.Text()
+			ircPacket := withRawFileName(Parse(decodeLine(line, e.Encoding)), line)
+			// Membership and replies depend on order of lines, so they are handled
+			// before packets get handled concurrently. Dispatching never blocks.
+			e.trackChannels(ircPacket)
+			e.trackPresence(ircPacket)
+			e.subscriptions.dispatch(ircPacket)
+
+			go func(packet Packet) {
+				if packet.Type == Ping {
+					e.sendPriority(fmt.Sprintf("PONG :%s", packet.Payload))
 				}
 
-				if packet.Type == RplEndOfNames {
-					e.onRplEndOfNamesMutex.RLock()
-					for _, callback := range e.onRplEndOfNames {
-						callback(packet)
-					}
-					e.onRplEndOfNamesMutex.RUnlock()
+				if packet.Type == PrivMsgCtcp {
+					e.handleCTCP(packet.Payload.(CTCPPayload))
 				}
 
-				if packet.Type == RplWhoisChannels {
-					e.onRplWhoisChannelsMutex.RLock()
-					for _, callback := range e.onRplWhoisChannels {
-						callback(packet)
-					}
-					e.onRplWhoisChannelsMutex.RUnlock()
+				if packet.Type != Unknown {
+					e.emit(packet)
 				}
+			}(ircPacket)
+		}
+	}()
+}
 
-				if packet.Type == Ping {
-					e.send(fmt.Sprintf("PONG :%s", packet.Payload))
-				}
+// emit sends the packet on IRCPacketsChann unless the engine stops first.
+func (e *Engine) emit(packet Packet) {
+	e.ircPacketsMutex.RLock()
+	defer e.ircPacketsMutex.RUnlock()
 
-				if e.ctx.Err() == nil && packet.Type != Unknown {
-					r <- packet
-				}
-			}(ircLine)
+	if e.ctx.Err() == nil {
+		select {
+		case e.ircPacketsChan <- packet:
+		case <-e.ctx.Done():
 		}
-	}()
+	}
 }
 
 // Stop terminates all activities and closes both all channels and IOs of the engine.
@@ -140,10 +184,16 @@ func (e *Engine) Context() context.Context {
 }
 
 // Register tries to register IRC nick until either it successes or gets cancelled.
+// Configured Nicks are tried first. Negotiates Capabilities and, when Auth is set,
+// authenticates with SASL and, if enabled, falls back to NickServ.
+// Results are available via EnabledCapabilities and AuthStatus.
+// Once registered, keeps the connection alive when PingIntervalMsec is set
+// and starts polling presence of watched nicks.
 // In most cases should be called right after Start.
 // Sends result on the returned channel.
 func (e *Engine) Register(ctx context.Context, tryTimeout int64) <-chan bool {
 	r := make(chan bool, 1)
+	timeout := time.Duration(tryTimeout) * time.Millisecond
 
 	go func() {
 		defer close(r)
@@ -151,50 +201,68 @@ func (e *Engine) Register(ctx context.Context, tryTimeout int64) <-chan bool {
 		registrationSuccess := false
 		registrationFail := false
 
-		for !registrationSuccess && !registrationFail {
-			successChann := make(chan bool, 1)
-			defer close(successChann)
+		sasl := e.Auth.SASLMechanism != ""
+		identify := e.Auth.NickServ && e.Auth.Password != ""
+		negotiation := sasl || len(e.Capabilities) > 0
+		negotiationCtx, cancelNegotiation := context.WithCancel(ctx)
+		defer cancelNegotiation()
 
-			currentNick := randNick()
+		if sasl || identify {
+			e.setAuthStatus(AuthPending)
+		}
+		var negotiationPromise <-chan bool
+		if negotiation {
+			negotiationPromise = e.negotiate(negotiationCtx, timeout)
+		}
+		// Servers hold registration until negotiation ends, so nick attempts do not time out before.
+		negotiating := negotiationPromise
 
-			welcomeCallback := func(packet Packet) {
-				if packet.Payload == currentNick {
-					e.nick = currentNick
-					successChann <- true
-				}
-			}
+		packets, unsubscribe := e.Subscribe(OfType(RplWelcome, ErrNicknameInUse))
+		defer unsubscribe()
 
-			nickTakenCallback := func(packet Packet) {
-				if packet.Payload == currentNick {
-					successChann <- false
-				}
+		for attempt := 0; !registrationSuccess && !registrationFail; attempt++ {
+			currentNick := randNick(e.nickLength())
+			if attempt < len(e.Nicks) {
+				currentNick = e.Nicks[attempt]
 			}
 
-			e.onRplWelcomeMutex.Lock()
-			e.onRplWelcome[currentNick] = welcomeCallback
-			e.onRplWelcomeMutex.Unlock()
-			e.onErrNicknameInUseMutex.Lock()
-			e.onErrNicknameInUse[currentNick] = nickTakenCallback
-			e.onErrNicknameInUseMutex.Unlock()
-
 			e.send(fmt.Sprintf("USER %s * * %s", currentNick, currentNick))
 			e.send(fmt.Sprintf("NICK %s", currentNick))
 
-			select {
-			case <-time.After(time.Duration(tryTimeout) * time.Millisecond):
-				registrationSuccess = false
-			case <-ctx.Done():
-				registrationFail = true
-			case success := <-successChann:
-				registrationSuccess = success
+			deadline := time.After(timeout)
+			for answered := false; !answered; {
+				select {
+				case <-negotiating:
+					negotiating = nil
+					deadline = time.After(timeout)
+				case <-deadline:
+					answered = negotiating == nil
+				case <-ctx.Done():
+					registrationFail, answered = true, true
+				case packet := <-packets:
+					if packet.Payload == currentNick {
+						registrationSuccess, answered = packet.Type == RplWelcome, true
+					}
+				}
 			}
+			if registrationSuccess {
+				e.setNick(currentNick)
+			}
+		}
+
+		cancelNegotiation()
+		if negotiation {
+			<-negotiationPromise
+		}
 
-			e.onRplWelcomeMutex.Lock()
-			delete(e.onRplWelcome, currentNick)
-			e.onRplWelcomeMutex.Unlock()
-			e.onErrNicknameInUseMutex.Lock()
-			delete(e.onErrNicknameInUse, currentNick)
-			e.onErrNicknameInUseMutex.Unlock()
+		if registrationSuccess && identify && e.AuthStatus() != AuthSASL {
+			e.identify(ctx, timeout)
+		}
+		if registrationSuccess && e.PingIntervalMsec > 0 {
+			go e.keepalive()
+		}
+		if registrationSuccess {
+			go e.pollPresence()
 		}
 
 		r <- registrationSuccess
@@ -203,110 +271,135 @@ func (e *Engine) Register(ctx context.Context, tryTimeout int64) <-chan bool {
 	return r
 }
 
-// Join tries to join IRC channel.
-// Sends result on the returned channel.
-// Considers result as a success even when gets timeouted.
-func (e *Engine) Join(ctx context.Context, channelName string) <-chan bool {
-	r := make(chan bool, 1)
-
-	go func() {
-		defer close(r)
+// ChannelsResult is a result of ChannelsOfUser.
+type ChannelsResult struct {
+	Channels []string
+	// Err is an IRCError when the server rejects the query, e.g. because the user is offline,
+	// or an error of the context.
+	Err error
+}
 
-		callbackSuccessChann := make(chan bool)
-		defer close(callbackSuccessChann)
+// Join tries to join IRC channel unless we are already on it.
+// Sends nil on the returned channel once joined, an IRCError when the server refuses,
+// e.g. because we are banned, or an error of the context.
+// Joined channels are rejoined after kicks until they are left with Part.
+// JOIN is queued before Join returns.
+func (e *Engine) Join(ctx context.Context, channelName string) <-chan error {
+	r := make(chan error, 1)
+
+	channelWithHash := withHash(channelName)
+	channelWithoutHash := channelWithHash[1:]
+	if e.isOn(channelWithHash) {
+		r <- nil
+		close(r)
+		return r
+	}
 
-		channelWithHash := channelName
-		if !strings.HasPrefix(channelName, "#") {
-			channelWithHash = fmt.Sprintf("#%s", channelName)
+	packets, unsubscribe := e.Subscribe(func(packet Packet) bool {
+		switch payload := packet.Payload.(type) {
+		case string:
+			return packet.Type == RplEndOfNames && strings.EqualFold(payload, channelWithoutHash)
+		case IRCError:
+			return strings.EqualFold(payload.Target, channelWithHash)
 		}
-		channelWithoutHash := channelWithHash[1:]
+		return false
+	})
 
-		callback := func(packet Packet) {
-			if packet.Payload == channelWithoutHash {
-				callbackSuccessChann <- true
-			}
-		}
-		e.onRplEndOfNamesMutex.Lock()
-		e.onRplEndOfNames[channelWithoutHash] = callback
-		e.onRplEndOfNamesMutex.Unlock()
+	e.send(fmt.Sprintf("JOIN %s", channelWithHash))
 
-		e.send(fmt.Sprintf("JOIN %s", channelWithHash))
+	go func() {
+		defer close(r)
+		defer unsubscribe()
 
 		select {
 		case <-ctx.Done():
-			r <- false
-		case <-callbackSuccessChann:
-			r <- true
+			r <- ctx.Err()
+		case packet := <-packets:
+			err, _ := packet.Payload.(IRCError)
+			if packet.Type == ErrReply {
+				r <- err
+			} else {
+				e.want(channelWithHash)
+				r <- nil
+			}
 		}
-
-		e.onRplEndOfNamesMutex.Lock()
-		delete(e.onRplEndOfNames, channelWithoutHash)
-		e.onRplEndOfNamesMutex.Unlock()
 	}()
 
 	return r
 }
 
 // ChannelsOfUser tries to obtain channels of user under given nick.
-// Sends the result on the returned channel.
-func (e *Engine) ChannelsOfUser(ctx context.Context, nick string) chan []string {
-	r := make(chan []string, 1)
+// Sends the result on the returned channel. Users on no visible channels get an empty list.
+// WHOIS is queued before ChannelsOfUser returns.
+func (e *Engine) ChannelsOfUser(ctx context.Context, nick string) <-chan ChannelsResult {
+	r := make(chan ChannelsResult, 1)
+
+	packets, unsubscribe := e.Subscribe(func(packet Packet) bool {
+		switch payload := packet.Payload.(type) {
+		case RplWhoisChannelsPayload:
+			return strings.EqualFold(payload.nick, nick)
+		case string:
+			return packet.Type == RplEndOfWhois && strings.EqualFold(payload, nick)
+		case IRCError:
+			return strings.EqualFold(payload.Target, nick)
+		}
+		return false
+	})
+
+	e.send(fmt.Sprintf("WHOIS %s", nick))
 
 	go func() {
 		defer close(r)
+		defer unsubscribe()
 
-		callbackChann := make(chan []string)
-		defer close(callbackChann)
-
-		callback := func(packet Packet) {
-			payload, payloadOk := packet.Payload.(RplWhoisChannelsPayload)
-
-			if !payloadOk {
+		// Channels may be listed in many RPL_WHOISCHANNELS lines until RPL_ENDOFWHOIS.
+		channels := []string{}
+		for {
+			select {
+			case <-ctx.Done():
+				r <- ChannelsResult{Channels: []string{}, Err: ctx.Err()}
+				return
+			case packet := <-packets:
+				switch payload := packet.Payload.(type) {
+				case RplWhoisChannelsPayload:
+					channels = append(channels, payload.channels...)
+					continue
+				case IRCError:
+					r <- ChannelsResult{Channels: []string{}, Err: payload}
+				default:
+					r <- ChannelsResult{Channels: channels}
+				}
 				return
 			}
-
-			if payload.nick == nick {
-				callbackChann <- payload.channels
-			}
-		}
-		e.onRplWhoisChannelsMutex.Lock()
-		e.onRplWhoisChannels[nick] = callback
-		e.onRplWhoisChannelsMutex.Unlock()
-
-		e.send(fmt.Sprintf("WHOIS %s", nick))
-
-		select {
-		case <-ctx.Done():
-			r <- make([]string, 0)
-		case channels := <-callbackChann:
-			r <- channels
 		}
-
-		e.onRplWhoisChannelsMutex.Lock()
-		delete(e.onRplWhoisChannels, nick)
-		e.onRplWhoisChannelsMutex.Unlock()
 	}()
 
 	return r
 }
 
+// Quit sends QUIT with given message ahead of queued lines.
+// Server closes the connection afterwards.
+func (e *Engine) Quit(message string) {
+	e.sendPriority(fmt.Sprintf("QUIT :%s", message))
+}
+
 // SendMessage sends a message to user under given nick.
 func (e *Engine) SendMessage(nick string, body string) {
 	e.send(fmt.Sprintf("PRIVMSG %s :%s", nick, body))
 }
 
-func (e *Engine) send(data string) {
-	_, err := fmt.Fprintf(e.ircStream, "%s\r\n", data)
+var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
-	if err != nil {
-		e.cancelFunc()
+func (e *Engine) nickLength() int {
+	if e.NickLength > 0 {
+		return e.NickLength
 	}
-}
 
-var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	return nickLength
+}
 
-func randNick() string {
-	b := make([]rune, nickLength)
+func randNick(length int) string {
+	b := make([]rune, length)
 	for i := range b {
 		b[i] = letterRunes[rand.Intn(len(letterRunes))]
 	}
@@ -3,9 +3,11 @@ package irc
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"regexp"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,7 +18,7 @@ var userPattern = regexp.MustCompile("USER (\\S*) \\* \\* (\\S*)")
 var nickPattern = regexp.MustCompile("NICK (\\S*)")
 
 func TestNick(t *testing.T) {
-	engine := &Engine{nick: "foo"}
+	engine := &Engine{nick: "foo", nickMutex: &sync.RWMutex{}}
 
 	assert.Equal(t, "foo", engine.Nick())
 }
@@ -163,7 +165,25 @@ func TestJoin(t *testing.T) {
 
 	client.Write([]byte(":irc.rizon.club 366 gharibol #foo :End of /NAMES list.\r\n"))
 
-	assert.True(t, <-promise)
+	assert.Nil(t, <-promise)
+}
+
+func TestJoinFails(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	promise := engine.Join(engine.Context(), "Foo")
+
+	scanner.Scan()
+	client.Write([]byte(":irc.rizon.club 474 gharibol #foo :Cannot join channel (+b)\r\n"))
+
+	err := <-promise
+	assert.True(t, errors.Is(err, ErrBannedFromChan))
+	assert.Equal(t, "#foo: Banned from channel", err.Error())
 }
 
 func TestJoinGetsCancelled(t *testing.T) {
@@ -181,7 +201,7 @@ func TestJoinGetsCancelled(t *testing.T) {
 		reader.ReadString('\r')
 	}()
 
-	assert.False(t, <-promise)
+	assert.Equal(t, context.Canceled, <-promise)
 }
 
 func TestChannelsOfUser(t *testing.T) {
@@ -197,8 +217,69 @@ func TestChannelsOfUser(t *testing.T) {
 	assert.Equal(t, "WHOIS JohnDoe", scanner.Text())
 
 	client.Write([]byte(":magnet.rizon.net 319 foo JohnDoe :%#HorribleSubs %#NIBL %#news\r\n"))
+	client.Write([]byte(":magnet.rizon.net 318 foo JohnDoe :End of /WHOIS list.\r\n"))
+
+	assert.Equal(t, ChannelsResult{Channels: []string{"HorribleSubs", "NIBL", "news"}}, <-promise)
+}
+
+func TestChannelsOfUserCollectsWholeReply(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	promise := engine.ChannelsOfUser(context.Background(), "JohnDoe")
+
+	scanner.Scan()
+	client.Write([]byte(
+		":magnet.rizon.net 311 foo JohnDoe ~john doe.host * :John Doe\r\n" +
+			":magnet.rizon.net 319 foo JohnDoe :@#HorribleSubs #NIBL\r\n" +
+			":magnet.rizon.net 319 foo JohnDoe :#news\r\n" +
+			":magnet.rizon.net 312 foo JohnDoe magnet.rizon.net :Rizon Client Server\r\n" +
+			":magnet.rizon.net 317 foo JohnDoe 42 1600000000 :seconds idle, signon time\r\n" +
+			":magnet.rizon.net 318 foo JohnDoe :End of /WHOIS list.\r\n",
+	))
+
+	assert.Equal(t, ChannelsResult{Channels: []string{"HorribleSubs", "NIBL", "news"}}, <-promise)
+}
+
+func TestChannelsOfUserWithoutChannels(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	promise := engine.ChannelsOfUser(context.Background(), "JohnDoe")
+
+	scanner.Scan()
+	client.Write([]byte(":magnet.rizon.net 318 foo johndoe :End of /WHOIS list.\r\n"))
 
-	assert.Equal(t, []string{"HorribleSubs", "NIBL", "news"}, <-promise)
+	assert.Equal(t, ChannelsResult{Channels: []string{}}, <-promise)
+}
+
+func TestChannelsOfOfflineUser(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	promise := engine.ChannelsOfUser(context.Background(), "JohnDoe")
+
+	scanner.Scan()
+	client.Write([]byte(
+		":magnet.rizon.net 401 foo JohnDoe :No such nick/channel\r\n" +
+			":magnet.rizon.net 318 foo JohnDoe :End of /WHOIS list.\r\n",
+	))
+
+	result := <-promise
+	assert.Empty(t, result.Channels)
+	assert.True(t, errors.Is(result.Err, ErrNoSuchNick))
 }
 
 func TestChannelsOfUserGetsCanceled(t *testing.T) {
@@ -216,7 +297,7 @@ func TestChannelsOfUserGetsCanceled(t *testing.T) {
 		reader.ReadString('\n')
 	}()
 
-	assert.Equal(t, []string{}, <-promise)
+	assert.Equal(t, ChannelsResult{Channels: []string{}, Err: context.Canceled}, <-promise)
 }
 
 func TestIRCPacketsChann(t *testing.T) {
@@ -247,6 +328,20 @@ func TestSendMessage(t *testing.T) {
 	assert.Equal(t, "PRIVMSG foo :bar", scanner.Text())
 }
 
+func TestQuit(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	go func() {
+		engine.Quit("bye")
+	}()
+
+	scanner.Scan()
+	assert.Equal(t, "QUIT :bye", scanner.Text())
+}
+
 func TestContextAndStop(t *testing.T) {
 	_, server := net.Pipe()
 
@@ -269,3 +364,19 @@ func TestEngineGetsCancelledOnConnectionClose(t *testing.T) {
 
 	<-ctx.Done()
 }
+
+func TestReqisterWithNickLength(t *testing.T) {
+	client, server := net.Pipe()
+	reader := bufio.NewReader(client)
+
+	engine := &Engine{NickLength: 12}
+	engine.Start(server)
+	engine.Register(engine.Context(), 999999)
+
+	reader.ReadString('\r')
+	nickRequest, _ := reader.ReadString('\r')
+	nickRequestParts := nickPattern.FindAllStringSubmatch(nickRequest, -1)[0]
+	assert.Len(t, nickRequestParts[1], 12)
+
+	engine.Stop()
+}
@@ -0,0 +1,50 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors that IRCError unwraps to, so they can be checked with errors.Is.
+var (
+	ErrNoSuchNick       = errors.New("No such nick")
+	ErrNoSuchChannel    = errors.New("No such channel")
+	ErrCannotSendToChan = errors.New("Cannot send to channel")
+	ErrTooManyChannels  = errors.New("Joined too many channels")
+	ErrChannelIsFull    = errors.New("Channel is full")
+	ErrInviteOnlyChan   = errors.New("Channel is invite only")
+	ErrBannedFromChan   = errors.New("Banned from channel")
+	ErrBadChannelKey    = errors.New("Channel requires key")
+	ErrNeedReggedNick   = errors.New("Channel requires registered nick")
+	ErrSecureOnlyChan   = errors.New("Channel requires TLS")
+)
+
+// numericErrors maps error numerics to their errors. Only numerics listed here are parsed as ErrReply.
+var numericErrors = map[string]error{
+	"401": ErrNoSuchNick,
+	"403": ErrNoSuchChannel,
+	"404": ErrCannotSendToChan,
+	"405": ErrTooManyChannels,
+	"471": ErrChannelIsFull,
+	"473": ErrInviteOnlyChan,
+	"474": ErrBannedFromChan,
+	"475": ErrBadChannelKey,
+	"477": ErrNeedReggedNick,
+	"489": ErrSecureOnlyChan,
+}
+
+// IRCError is an error reply of the server. Target is the nick or channel it refers to.
+type IRCError struct {
+	Code   string
+	Target string
+	Text   string
+}
+
+func (e IRCError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Target, e.Unwrap())
+}
+
+// Unwrap returns one of the ErrNoSuchNick-like errors.
+func (e IRCError) Unwrap() error {
+	return numericErrors[e.Code]
+}
@@ -0,0 +1,66 @@
+package irc
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lag returns round-trip time of the last keepalive PING, zero until one is answered.
+func (e *Engine) Lag() time.Duration {
+	e.lagMutex.RLock()
+	defer e.lagMutex.RUnlock()
+
+	return e.lag
+}
+
+func (e *Engine) pingTimeout() time.Duration {
+	if e.PingTimeoutMsec > 0 {
+		return time.Duration(e.PingTimeoutMsec) * time.Millisecond
+	}
+
+	return time.Duration(e.PingIntervalMsec) * time.Millisecond
+}
+
+// keepalive PINGs the server every PingIntervalMsec and stops the engine when
+// PONG does not come back in time, e.g. because the connection was silently dropped.
+func (e *Engine) keepalive() {
+	interval := time.Duration(e.PingIntervalMsec) * time.Millisecond
+
+	for {
+		select {
+		case <-time.After(interval):
+		case <-e.ctx.Done():
+			return
+		}
+
+		if !e.ping() {
+			e.cancelFunc()
+			return
+		}
+	}
+}
+
+// ping sends PING with a unique token and waits for PONG echoing it. Records the lag.
+func (e *Engine) ping() bool {
+	token := fmt.Sprintf("animuxd-%d", time.Now().UnixNano())
+	packets, unsubscribe := e.Subscribe(func(packet Packet) bool {
+		m := packet.Message
+		return m.Command == "PONG" && m.ParamsCount() > 0 && m.Param(m.ParamsCount()-1) == token
+	})
+	defer unsubscribe()
+
+	sentAt := time.Now()
+	e.sendPriority("PING :" + token)
+
+	select {
+	case <-packets:
+		e.lagMutex.Lock()
+		e.lag = time.Since(sentAt)
+		e.lagMutex.Unlock()
+		return true
+	case <-time.After(e.pingTimeout()):
+		return false
+	case <-e.ctx.Done():
+		return true
+	}
+}
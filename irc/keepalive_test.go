@@ -0,0 +1,71 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepaliveMeasuresLag(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{PingIntervalMsec: 10, PingTimeoutMsec: 1000}
+	engine.Start(server)
+	defer engine.Stop()
+	go engine.keepalive()
+
+	assert.Equal(t, time.Duration(0), engine.Lag())
+
+	scanner.Scan()
+	assert.True(t, strings.HasPrefix(scanner.Text(), "PING :animuxd-"))
+	token := strings.TrimPrefix(scanner.Text(), "PING :")
+
+	time.Sleep(5 * time.Millisecond)
+	client.Write([]byte(":irc.rizon.club PONG irc.rizon.club :" + token + "\r\n"))
+
+	scanner.Scan()
+	assert.True(t, strings.HasPrefix(scanner.Text(), "PING :animuxd-"))
+	assert.True(t, engine.Lag() >= 5*time.Millisecond)
+	assert.Nil(t, engine.Context().Err())
+}
+
+func TestKeepaliveStopsEngine(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{PingIntervalMsec: 10, PingTimeoutMsec: 20}
+	engine.Start(server)
+	go engine.keepalive()
+
+	scanner.Scan()
+	client.Write([]byte(":irc.rizon.club PONG irc.rizon.club :foo\r\n"))
+
+	select {
+	case <-engine.Context().Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "engine was not stopped")
+	}
+}
+
+func TestKeepaliveStartsOnRegister(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{Nicks: []string{"ownadi"}, PingIntervalMsec: 10}
+	engine.Start(server)
+	defer engine.Stop()
+	promise := engine.Register(engine.Context(), 1000)
+
+	scanner.Scan()
+	scanner.Scan()
+	client.Write([]byte(":irc.rizon.club 001 ownadi :Welcome\r\n"))
+	assert.True(t, <-promise)
+
+	scanner.Scan()
+	assert.True(t, strings.HasPrefix(scanner.Text(), "PING :animuxd-"))
+}
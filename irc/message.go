@@ -0,0 +1,234 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// maxParams is the maximum number of parameters of a message, including the trailing one.
+const maxParams = 15
+
+// Prefix is the origin of a message, either a server name or nick!user@host.
+type Prefix struct {
+	Name string
+	User string
+	Host string
+}
+
+func (p Prefix) String() string {
+	s := p.Name
+	if p.User != "" {
+		s += "!" + p.User
+	}
+	if p.Host != "" {
+		s += "@" + p.Host
+	}
+
+	return s
+}
+
+// Message is a single IRC message as described in RFC 2812, section 2.3.1,
+// optionally preceded by IRCv3 message tags.
+type Message struct {
+	// Raw is the line the message was parsed from, without line ending.
+	Raw string
+	// Tags are unescaped values of IRCv3 message tags. Tags without value map to empty string.
+	Tags    map[string]string
+	Prefix  Prefix
+	Command string
+	// Params are middle parameters. The trailing one is kept apart.
+	Params      []string
+	Trailing    string
+	HasTrailing bool
+}
+
+// ParseMessage parses a single line. Commands are upper-cased.
+func ParseMessage(line string) (Message, error) {
+	line = strings.TrimRight(line, "\r\n")
+	m := Message{Raw: line, Tags: map[string]string{}, Params: []string{}}
+	rest := line
+
+	if strings.HasPrefix(rest, "@") {
+		var tags string
+		tags, rest = cutWord(rest[1:])
+		if tags == "" {
+			return m, errors.New("Empty tags")
+		}
+		m.Tags = parseTags(tags)
+	}
+
+	if strings.HasPrefix(rest, ":") {
+		var prefix string
+		prefix, rest = cutWord(rest[1:])
+		if prefix == "" {
+			return m, errors.New("Empty prefix")
+		}
+		m.Prefix = parsePrefix(prefix)
+	}
+
+	m.Command, rest = cutWord(rest)
+	if !validCommand(m.Command) {
+		return m, errors.New("Invalid command")
+	}
+	m.Command = strings.ToUpper(m.Command)
+
+	for rest != "" {
+		if strings.HasPrefix(rest, ":") || len(m.Params) == maxParams-1 {
+			m.Trailing = strings.TrimPrefix(rest, ":")
+			m.HasTrailing = true
+			break
+		}
+
+		var param string
+		param, rest = cutWord(rest)
+		m.Params = append(m.Params, param)
+	}
+
+	return m, nil
+}
+
+// Param returns parameter under given index, counting the trailing one as the last.
+// Returns empty string when there is no such parameter.
+func (m Message) Param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	if i == len(m.Params) && m.HasTrailing {
+		return m.Trailing
+	}
+
+	return ""
+}
+
+// ParamsCount returns number of parameters, including the trailing one.
+func (m Message) ParamsCount() int {
+	if m.HasTrailing {
+		return len(m.Params) + 1
+	}
+
+	return len(m.Params)
+}
+
+// Time returns time the server received the message at, taken from the server-time tag.
+func (m Message) Time() (time.Time, bool) {
+	value, ok := m.Tags["time"]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// Account returns services account of the sender, taken from the account-tag tag.
+// Returns empty string when the sender is not logged in or the tag is missing.
+func (m Message) Account() string {
+	if account := m.Tags["account"]; account != "*" {
+		return account
+	}
+
+	return ""
+}
+
+// cutWord splits off everything up to the first space. Following spaces are skipped.
+func cutWord(s string) (string, string) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+
+	return s[:i], strings.TrimLeft(s[i:], " ")
+}
+
+func parsePrefix(prefix string) Prefix {
+	p := Prefix{Name: prefix}
+
+	if i := strings.IndexByte(p.Name, '@'); i >= 0 {
+		p.Name, p.Host = p.Name[:i], p.Name[i+1:]
+	}
+	if i := strings.IndexByte(p.Name, '!'); i >= 0 {
+		p.Name, p.User = p.Name[:i], p.Name[i+1:]
+	}
+
+	return p
+}
+
+func parseTags(tags string) map[string]string {
+	parsed := map[string]string{}
+
+	for _, tag := range strings.Split(tags, ";") {
+		if tag == "" {
+			continue
+		}
+
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 1 {
+			parsed[kv[0]] = ""
+		} else {
+			parsed[kv[0]] = unescapeTagValue(kv[1])
+		}
+	}
+
+	return parsed
+}
+
+// unescapeTagValue reverses escaping described in IRCv3 message-tags specification.
+// Unknown escapes lose the backslash and a lone trailing backslash is dropped.
+func unescapeTagValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		if i == len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
+
+// validCommand tells whether command consists of letters only or exactly three digits.
+func validCommand(command string) bool {
+	if command == "" {
+		return false
+	}
+
+	letters, digits := 0, 0
+	for _, c := range command {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			letters++
+		case c >= '0' && c <= '9':
+			digits++
+		default:
+			return false
+		}
+	}
+
+	return digits == 0 || (letters == 0 && digits == 3)
+}
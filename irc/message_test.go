@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMessage(t *testing.T) {
+	m, err := ParseMessage(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :Hello there!\r\n")
+
+	assert.Nil(t, err)
+	assert.Equal(t, ":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :Hello there!", m.Raw)
+	assert.Equal(t, Prefix{Name: "Gintoki", User: "~Gin", Host: "oshiete.ginpachi.sensei"}, m.Prefix)
+	assert.Equal(t, "Gintoki!~Gin@oshiete.ginpachi.sensei", m.Prefix.String())
+	assert.Equal(t, "PRIVMSG", m.Command)
+	assert.Equal(t, []string{"ownadi"}, m.Params)
+	assert.Equal(t, "Hello there!", m.Trailing)
+	assert.True(t, m.HasTrailing)
+	assert.Equal(t, 2, m.ParamsCount())
+	assert.Equal(t, "Hello there!", m.Param(1))
+	assert.Equal(t, "", m.Param(2))
+}
+
+func TestParseMessageWithoutPrefixAndTrailing(t *testing.T) {
+	m, err := ParseMessage("join  #foo   key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Prefix{}, m.Prefix)
+	assert.Equal(t, "JOIN", m.Command)
+	assert.Equal(t, []string{"#foo", "key"}, m.Params)
+	assert.False(t, m.HasTrailing)
+	assert.Equal(t, 2, m.ParamsCount())
+}
+
+func TestParseMessageServerPrefix(t *testing.T) {
+	m, err := ParseMessage(":irc.rizon.club 366 ownadi #foo :")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Prefix{Name: "irc.rizon.club"}, m.Prefix)
+	assert.Equal(t, "366", m.Command)
+	assert.True(t, m.HasTrailing)
+	assert.Equal(t, "", m.Trailing)
+}
+
+func TestParseMessageFifteenParams(t *testing.T) {
+	m, err := ParseMessage("FOO 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16")
+
+	assert.Nil(t, err)
+	assert.Len(t, m.Params, 14)
+	assert.Equal(t, "15 16", m.Trailing)
+}
+
+func TestParseMessageTags(t *testing.T) {
+	m, err := ParseMessage(`@time=2020-05-01T12:30:00.123Z;account=gin;msgid;+example.com/note=a\:b\sc\\d\ :Gintoki!~Gin@h PRIVMSG ownadi :hi`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"time":              "2020-05-01T12:30:00.123Z",
+		"account":           "gin",
+		"msgid":             "",
+		"+example.com/note": "a;b c\\d",
+	}, m.Tags)
+	assert.Equal(t, "Gintoki", m.Prefix.Name)
+	assert.Equal(t, "PRIVMSG", m.Command)
+	assert.Equal(t, "gin", m.Account())
+
+	at, ok := m.Time()
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2020, 5, 1, 12, 30, 0, 123000000, time.UTC), at)
+}
+
+func TestParseMessageWithoutTags(t *testing.T) {
+	m, _ := ParseMessage("@time=yesterday;account=* PING :foo")
+
+	_, ok := m.Time()
+	assert.False(t, ok)
+	assert.Equal(t, "", m.Account())
+
+	m, _ = ParseMessage("PING :foo")
+
+	assert.Empty(t, m.Tags)
+	_, ok = m.Time()
+	assert.False(t, ok)
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	for _, line := range []string{"", "@", "@a=b", ":", ": PING", ":foo", "12 foo", "1234 foo", "PI-NG foo", "\r\n"} {
+		_, err := ParseMessage(line)
+
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestParseNeverPanics(t *testing.T) {
+	lines := []string{
+		":irc.rizon.club 366",
+		":irc.rizon.club 366 ownadi",
+		":irc.rizon.club 366 ownadi :",
+		":irc.rizon.club 319 ownadi",
+		":irc.rizon.club 319 ownadi foo :",
+		":irc.rizon.club 433",
+		":irc.rizon.club CAP",
+		":irc.rizon.club 900 ownadi",
+		"PRIVMSG",
+		"PRIVMSG ownadi :\x01DCC SEND",
+		"PRIVMSG ownadi :\x01DCC SEND \"",
+		"PING",
+		"@a=\\ PING",
+		"@;;= PING",
+		strings.Repeat(" ", 100),
+	}
+
+	for _, line := range lines {
+		assert.NotPanics(t, func() { Parse(line) }, line)
+	}
+}
@@ -3,7 +3,6 @@ package irc
 import (
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"net"
 	"regexp"
 	"strconv"
@@ -19,12 +18,31 @@ const (
 	RplEndOfNames
 	ErrNicknameInUse
 	PrivMsgDccSend
+	Cap
+	Authenticate
+	RplLoggedIn
+	RplSaslSuccess
+	ErrSaslFail
+	PrivMsgCtcp
+	PrivMsg
+	Notice
+	PrivMsgDccAccept
+	RplEndOfWhois
+	ErrReply
+	Quit
+	NickChange
+	RplMonOnline
+	RplMonOffline
+	RplIson
+	Presence
 	Unknown
 )
 
+// Packet is a recognized message. Message is kept for anything Payload does not cover.
 type Packet struct {
 	Type    PacketType
 	Payload interface{}
+	Message Message
 }
 
 type RplWhoisChannelsPayload struct {
@@ -32,118 +50,404 @@ type RplWhoisChannelsPayload struct {
 	channels []string
 }
 
+// CapPayload describes a reply to capability negotiation.
+// More is set when the server continues the list in the next reply.
+type CapPayload struct {
+	Subcommand   string
+	Capabilities []string
+	More         bool
+}
+
+// PrivMsgDccAcceptPayload confirms our DCC RESUME. Transfer continues from Position.
+type PrivMsgDccAcceptPayload struct {
+	FileName string
+	Port     uint64
+	Position int64
+	Token    string
+}
+
+// NickChangePayload describes a user changing nick from Old to New.
+type NickChangePayload struct {
+	Old string
+	New string
+}
+
+// TextPayload is a plain PRIVMSG or NOTICE. Nick is the sender, Target is either us or a channel.
+type TextPayload struct {
+	Nick   string
+	Target string
+	Text   string
+}
+
+// PrivMsgDccSendPayload is a DCC SEND offer. The bot's address is either IP,
+// IPv4 or IPv6, or Host when the bot sent a hostname.
+// Passive offers have port 0 and a Token to be sent back along with our address.
+// Secure offers, i.e. DCC SSEND, are sent over TLS.
 type PrivMsgDccSendPayload struct {
 	FileName   string
 	FileLength int64
 	IP         net.IP
+	Host       string
 	Port       uint64
+	Token      string
+	Secure     bool
+	// RawFileName is the file name as received, before decoding. Set only when it differs.
+	RawFileName string
+}
+
+// OfferedFileName returns the file name as the bot sent it, to be used in replies to the offer.
+func (p PrivMsgDccSendPayload) OfferedFileName() string {
+	if p.RawFileName != "" {
+		return p.RawFileName
+	}
+
+	return p.FileName
+}
+
+// Passive tells whether the bot waits for us to listen instead of listening itself.
+func (p PrivMsgDccSendPayload) Passive() bool {
+	return p.Port == 0 && p.Token != ""
+}
+
+// Address returns host:port of the bot, e.g. [::1]:1337.
+func (p PrivMsgDccSendPayload) Address() string {
+	host := p.Host
+	if host == "" {
+		host = p.IP.String()
+	}
+
+	return net.JoinHostPort(host, strconv.FormatUint(p.Port, 10))
 }
 
 const (
-	ping             = "PING"
-	privmsg          = "PRIVMSG"
-	rplWelcome       = "001"
-	rplWhoisChannels = "319"
-	rplEndOfNames    = "366"
-	errNicknameInUse = "433"
-	dccSendMsgStart  = "\x01DCC SEND "
+	ping              = "PING"
+	quit              = "QUIT"
+	nickCmd           = "NICK"
+	privmsg           = "PRIVMSG"
+	notice            = "NOTICE"
+	capCmd            = "CAP"
+	authenticate      = "AUTHENTICATE"
+	rplWelcome        = "001"
+	rplISupport       = "005"
+	rplIson           = "303"
+	rplWhoisChannels  = "319"
+	rplEndOfWhois     = "318"
+	rplNamReply       = "353"
+	rplEndOfNames     = "366"
+	errNicknameInUse  = "433"
+	rplMonOnline      = "730"
+	rplMonOffline     = "731"
+	errMonListFull    = "734"
+	rplLoggedIn       = "900"
+	errNickLocked     = "902"
+	rplSaslSuccess    = "903"
+	errSaslFail       = "904"
+	errSaslTooLong    = "905"
+	errSaslAborted    = "906"
+	dccSendMsgStart   = "\x01DCC SEND "
+	dccSsendMsgStart  = "\x01DCC SSEND "
+	dccAcceptMsgStart = "\x01DCC ACCEPT "
 )
 
-var pattern = regexp.MustCompile(
-	fmt.Sprintf(
-		"^:\\S* (%s|%s|%s|%s|%s) (\\S*) :?(.*)$",
-		privmsg, rplWhoisChannels, rplWelcome, rplEndOfNames, errNicknameInUse,
-	),
-)
-var pingPattern = regexp.MustCompile(
-	fmt.Sprintf("^(%s) :(\\S*)$", ping),
-)
-var dccSendMsgPattern = regexp.MustCompile(
-	"^\x01?DCC SEND \"?([^\"]*)\"? ([0-9]*) ([0-9]*) ([0-9]*)",
-)
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*\.?$`)
 
-// Parse is a poor man's IRC parser. It supports only small subset of the protocol that
-// satisfies needs of XDCC downloader.
+// minParams is the number of parameters, including the trailing one,
+// a message needs to be recognized as a packet of given command.
+var minParams = map[string]int{
+	ping:             1,
+	authenticate:     1,
+	capCmd:           2,
+	rplLoggedIn:      3,
+	rplWelcome:       1,
+	rplWhoisChannels: 2,
+	rplEndOfWhois:    2,
+	nickCmd:          1,
+	rplIson:          2,
+	rplMonOnline:     2,
+	rplMonOffline:    2,
+	rplEndOfNames:    2,
+	errNicknameInUse: 2,
+	privmsg:          2,
+	notice:           2,
+}
+
+// Parse parses a line into a Message and recognizes the packet it carries.
+// Messages which are not recognized are of Unknown type and can be inspected through Message.
 func Parse(line string) Packet {
-	captures := pingPattern.FindAllStringSubmatch(line, -1)
+	m, err := ParseMessage(line)
+	if err != nil || m.ParamsCount() < minParams[m.Command] {
+		return Packet{Type: Unknown, Message: m}
+	}
 
-	if captures == nil {
-		captures = pattern.FindAllStringSubmatch(line, -1)
+	packet := Packet{Type: Unknown, Message: m}
+
+	switch m.Command {
+	case ping:
+		packet.Type, packet.Payload = Ping, m.Param(0)
+	case authenticate:
+		packet.Type, packet.Payload = Authenticate, m.Param(0)
+	case capCmd:
+		packet.Type, packet.Payload = Cap, parseCapPayload(m)
+	case rplLoggedIn:
+		packet.Type, packet.Payload = RplLoggedIn, m.Param(2)
+	case rplSaslSuccess:
+		packet.Type = RplSaslSuccess
+	case errNickLocked, errSaslFail, errSaslTooLong, errSaslAborted:
+		packet.Type, packet.Payload = ErrSaslFail, m.Command
+	case rplWelcome:
+		packet.Type, packet.Payload = RplWelcome, m.Param(0)
+	case rplWhoisChannels:
+		packet.Type, packet.Payload = RplWhoisChannels, parseRplWhoisChannelsPayload(m)
+	case rplEndOfNames:
+		packet.Type, packet.Payload = RplEndOfNames, strings.TrimPrefix(m.Param(1), "#")
+	case errNicknameInUse:
+		packet.Type, packet.Payload = ErrNicknameInUse, m.Param(1)
+	case rplEndOfWhois:
+		packet.Type, packet.Payload = RplEndOfWhois, m.Param(1)
+	case quit:
+		packet.Type, packet.Payload = Quit, m.Prefix.Name
+	case nickCmd:
+		packet.Type, packet.Payload = NickChange, NickChangePayload{Old: m.Prefix.Name, New: m.Param(0)}
+	case rplMonOnline:
+		packet.Type, packet.Payload = RplMonOnline, parseMonitorTargets(m.Param(1))
+	case rplMonOffline:
+		packet.Type, packet.Payload = RplMonOffline, parseMonitorTargets(m.Param(1))
+	case rplIson:
+		packet.Type, packet.Payload = RplIson, strings.Fields(m.Param(1))
+	case privmsg:
+		text := m.Param(1)
+		if strings.HasPrefix(text, dccSendMsgStart) || strings.HasPrefix(text, dccSsendMsgStart) {
+			payload, err := parseDccSendMsgPayload(text)
+			if err == nil {
+				packet.Type, packet.Payload = PrivMsgDccSend, payload
+			}
+		} else if strings.HasPrefix(text, dccAcceptMsgStart) {
+			payload, err := parseDccAcceptMsgPayload(text)
+			if err == nil {
+				packet.Type, packet.Payload = PrivMsgDccAccept, payload
+			}
+		} else if command, params, ok := parseCTCP(text); ok {
+			packet.Type = PrivMsgCtcp
+			packet.Payload = CTCPPayload{Nick: m.Prefix.Name, Command: command, Params: params}
+		} else if !strings.HasPrefix(text, ctcpDelimiter) {
+			packet.Type, packet.Payload = PrivMsg, TextPayload{Nick: m.Prefix.Name, Target: m.Param(0), Text: text}
+		}
+	case notice:
+		text := m.Param(1)
+		if !strings.HasPrefix(text, ctcpDelimiter) {
+			packet.Type, packet.Payload = Notice, TextPayload{Nick: m.Prefix.Name, Target: m.Param(0), Text: text}
+		}
+	default:
+		if _, ok := numericErrors[m.Command]; ok && m.ParamsCount() >= 2 {
+			packet.Type = ErrReply
+			packet.Payload = IRCError{Code: m.Command, Target: m.Param(1), Text: m.Param(m.ParamsCount() - 1)}
+		}
 	}
 
-	if captures == nil {
-		return Packet{Type: Unknown}
+	return packet
+}
+
+// parseRplWhoisChannelsPayload strips membership prefixes, e.g. @ or %, and hashes off channels.
+func parseRplWhoisChannelsPayload(m Message) RplWhoisChannelsPayload {
+	fields := strings.Fields(m.Param(m.ParamsCount() - 1))
+	channels := make([]string, 0, len(fields))
+	for _, field := range fields {
+		channels = append(channels, strings.TrimPrefix(strings.TrimLeft(field, "~&@%+"), "#"))
 	}
-	parts := captures[0]
 
-	if parts[1] == ping {
-		return Packet{Type: Ping, Payload: parts[2]}
+	return RplWhoisChannelsPayload{nick: m.Param(1), channels: channels}
+}
+
+// parseMonitorTargets turns "nick!user@host,nick2" lists of MONITOR replies into nicks.
+func parseMonitorTargets(targets string) []string {
+	nicks := []string{}
+	for _, target := range strings.Split(targets, ",") {
+		if nick := strings.SplitN(target, "!", 2)[0]; nick != "" {
+			nicks = append(nicks, nick)
+		}
 	}
 
-	if parts[1] == rplWelcome {
-		return Packet{Type: RplWelcome, Payload: parts[2]}
+	return nicks
+}
+
+// parseCapPayload handles both "CAP <target> <subcommand> :<capabilities>" and
+// multiline "CAP <target> <subcommand> * :<capabilities>" replies.
+func parseCapPayload(m Message) CapPayload {
+	payload := CapPayload{Subcommand: strings.ToUpper(m.Param(1)), Capabilities: []string{}}
+
+	count := m.ParamsCount()
+	if count >= 4 && m.Param(2) == "*" {
+		payload.More = true
+	}
+	if count >= 3 {
+		payload.Capabilities = strings.Fields(m.Param(count - 1))
 	}
 
-	if parts[1] == rplWhoisChannels {
-		return Packet{Type: RplWhoisChannels, Payload: parseRplWhoisChannelsPayload(parts[3])}
+	return payload
+}
+
+// parseDccSendMsgPayload parses "DCC SEND <file> <address> <port> <size>" and passive
+// "DCC SEND <file> <address> 0 <size> <token>" offers, as well as their SSEND counterparts.
+// File is either quoted or spans all words before the address.
+func parseDccSendMsgPayload(data string) (PrivMsgDccSendPayload, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(data, ctcpDelimiter), "DCC ")
+	secure := strings.HasPrefix(rest, "SSEND ")
+	rest = strings.TrimPrefix(strings.TrimPrefix(rest, "SEND "), "SSEND ")
+	rest = strings.TrimSuffix(strings.TrimLeft(rest, " "), ctcpDelimiter)
+
+	fileName, fields, err := splitDccArgs(rest, 3)
+	if err != nil {
+		return PrivMsgDccSendPayload{}, err
+	}
+	// Replies to passive offers carry the token along with a non-zero port.
+	token := ""
+	if len(fields) >= 4 {
+		token = fields[3]
 	}
 
-	if parts[1] == rplEndOfNames {
-		return Packet{Type: RplEndOfNames, Payload: parseRplEndOfNamesChannel(parts[3])}
+	ip, host, err := parseDccAddress(fields[0])
+	if err != nil {
+		return PrivMsgDccSendPayload{}, err
+	}
+	port, parsePortErr := strconv.ParseUint(fields[1], 10, 16)
+	// Some bots append garbage to the size, hence only leading digits count.
+	fileLength, parseFileLengthErr := strconv.ParseInt(leadingDigits(fields[2]), 10, 64)
+	if parsePortErr != nil || parseFileLengthErr != nil {
+		return PrivMsgDccSendPayload{}, errors.New("Could not parse number")
 	}
 
-	if parts[1] == errNicknameInUse {
-		return Packet{Type: ErrNicknameInUse, Payload: strings.SplitN(parts[3], " ", 2)[0]}
+	return PrivMsgDccSendPayload{FileName: fileName, IP: ip, Host: host, Port: port, FileLength: fileLength, Token: token, Secure: secure}, nil
+}
+
+// parseDccAcceptMsgPayload parses "DCC ACCEPT <file> <port> <position>" with optional passive token.
+func parseDccAcceptMsgPayload(data string) (PrivMsgDccAcceptPayload, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(data, ctcpDelimiter), "DCC ACCEPT ")
+	rest = strings.TrimSuffix(strings.TrimLeft(rest, " "), ctcpDelimiter)
+
+	fileName, fields, err := splitDccArgs(rest, 2)
+	if err != nil {
+		return PrivMsgDccAcceptPayload{}, err
+	}
+	token := ""
+	if len(fields) >= 3 {
+		token = fields[2]
 	}
 
-	if parts[1] == privmsg {
-		if strings.HasPrefix(parts[3], dccSendMsgStart) {
-			payload, err := parseDccSendMsgPayload(parts[3])
-			if err == nil {
-				return Packet{Type: PrivMsgDccSend, Payload: payload}
-			}
-		}
+	port, parsePortErr := strconv.ParseUint(fields[0], 10, 16)
+	position, parsePositionErr := strconv.ParseInt(fields[1], 10, 64)
+	if parsePortErr != nil || parsePositionErr != nil || position < 0 {
+		return PrivMsgDccAcceptPayload{}, errors.New("Could not parse number")
 	}
 
-	return Packet{Type: Unknown}
+	return PrivMsgDccAcceptPayload{FileName: fileName, Port: port, Position: position, Token: token}, nil
 }
 
-func parseRplWhoisChannelsPayload(data string) RplWhoisChannelsPayload {
-	parts := strings.Split(data, " ")
-	channelTrashesPattern := regexp.MustCompile("^:?%#")
+// splitDccArgs splits off the file name, either quoted or spanning all words before
+// the last count ones. Forms with a passive token, see hasDccToken, have one word more.
+func splitDccArgs(rest string, count int) (string, []string, error) {
+	var fileName string
+	var fields []string
 
-	channels := make([]string, len(parts)-1)
-	for i, dirtyChannel := range parts[1:] {
-		channels[i] = channelTrashesPattern.ReplaceAllString(dirtyChannel, "")
+	if strings.HasPrefix(rest, "\"") {
+		var after string
+		var err error
+		fileName, after, err = cutQuoted(rest)
+		if err != nil {
+			return "", nil, err
+		}
+		fields = strings.Fields(after)
+	} else {
+		fields = strings.Fields(rest)
+		if len(fields) < count+1 {
+			return "", nil, errors.New("Wrong format")
+		}
+		last := len(fields) - count
+		if len(fields) >= count+2 && hasDccToken(fields, count) {
+			last--
+		}
+		fileName, fields = strings.Join(fields[:last], " "), fields[last:]
+	}
+	if fileName == "" || len(fields) < count {
+		return "", nil, errors.New("Wrong format")
 	}
 
-	return RplWhoisChannelsPayload{nick: parts[0], channels: channels}
+	return fileName, fields, nil
 }
 
-func parseRplEndOfNamesChannel(data string) string {
-	return strings.SplitN(data, " ", 2)[0][1:]
+// hasDccToken tells whether unquoted arguments end with a passive token. As names may contain
+// spaces, it is assumed only when the port before the token is 0 or, in DCC SEND, when the words
+// make a plausible address only with the token.
+func hasDccToken(fields []string, count int) bool {
+	token := fields[len(fields)-1]
+	if token == "" || leadingDigits(token) != token {
+		return false
+	}
+	if fields[len(fields)-3] == "0" {
+		return true
+	}
+
+	return count == 3 && !plausibleDccAddress(fields[len(fields)-3]) && plausibleDccAddress(fields[len(fields)-4])
 }
 
-func parseDccSendMsgPayload(data string) (PrivMsgDccSendPayload, error) {
-	msgCaptures := dccSendMsgPattern.FindAllStringSubmatch(data, -1)
+// plausibleDccAddress tells whether the word may be an address of an offer. Integers below 2^24
+// are valid, but more likely ports or sizes.
+func plausibleDccAddress(word string) bool {
+	if leadingDigits(word) == word {
+		ipU64, err := strconv.ParseUint(word, 10, 32)
+		return err == nil && ipU64 >= 1<<24
+	}
+	_, _, err := parseDccAddress(word)
 
-	if len(msgCaptures) >= 1 {
-		msgParts := msgCaptures[0]
-		if msgCaptures != nil && len(msgParts) >= 5 {
-			port, parsePortErr := strconv.ParseUint(msgParts[3], 10, 64)
-			fileLength, parseFileLengthErr := strconv.ParseInt(msgParts[4], 10, 64)
-			ipU64, parseIPErr := strconv.ParseUint(msgParts[2], 10, 64)
+	return err == nil
+}
 
-			ip := make(net.IP, 4)
-			binary.BigEndian.PutUint32(ip, uint32(ipU64))
+// cutQuoted splits off a quoted file name, unescaping \" and \\ in it.
+func cutQuoted(s string) (string, string, error) {
+	var b strings.Builder
 
-			if parsePortErr != nil || parseFileLengthErr != nil || parseIPErr != nil {
-				return PrivMsgDccSendPayload{}, errors.New("Could not parse number")
-			}
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+			i++
+			b.WriteByte(s[i])
+		case s[i] == '"':
+			return b.String(), s[i+1:], nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
 
-			return PrivMsgDccSendPayload{FileName: msgParts[1], IP: ip, Port: port, FileLength: fileLength}, nil
+	return "", "", errors.New("Unterminated file name")
+}
+
+// parseDccAddress accepts an IPv4 address as a 32-bit integer, which is the classic form,
+// as well as IPv4 and IPv6 literals and hostnames.
+func parseDccAddress(address string) (net.IP, string, error) {
+	if leadingDigits(address) == address {
+		ipU64, err := strconv.ParseUint(address, 10, 32)
+		if err != nil {
+			return nil, "", errors.New("Invalid IPv4 address")
 		}
+
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(ipU64))
+		return ip, "", nil
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		return ip, "", nil
+	}
+	if hostnamePattern.MatchString(address) {
+		return nil, address, nil
+	}
+
+	return nil, "", errors.New("Invalid address")
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
 	}
 
-	return PrivMsgDccSendPayload{}, errors.New("Wrong format")
+	return s[:i]
 }
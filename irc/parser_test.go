@@ -1,6 +1,7 @@
 package irc
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,6 +40,47 @@ func TestErrNicknameInUse(t *testing.T) {
 	assert.Equal(t, "gourangaharibol", res.Payload)
 }
 
+func TestRplEndOfWhois(t *testing.T) {
+	res := Parse(":magnet.rizon.net 318 foo JohnDoe :End of /WHOIS list.")
+
+	assert.Equal(t, RplEndOfWhois, res.Type)
+	assert.Equal(t, "JohnDoe", res.Payload)
+}
+
+func TestErrReply(t *testing.T) {
+	res := Parse(":irc.rizon.club 477 gharibol #foo :Cannot join channel (+r)")
+
+	assert.Equal(t, ErrReply, res.Type)
+	assert.Equal(t, IRCError{Code: "477", Target: "#foo", Text: "Cannot join channel (+r)"}, res.Payload)
+	assert.True(t, errors.Is(res.Payload.(error), ErrNeedReggedNick))
+
+	res = Parse(":irc.rizon.club 482 gharibol #foo :You're not channel operator")
+
+	assert.Equal(t, Unknown, res.Type)
+}
+
+func TestPresenceMessages(t *testing.T) {
+	res := Parse(":b0t!b@h QUIT :Ping timeout")
+	assert.Equal(t, Quit, res.Type)
+	assert.Equal(t, "b0t", res.Payload)
+
+	res = Parse(":b0t!b@h NICK b0t|away")
+	assert.Equal(t, NickChange, res.Type)
+	assert.Equal(t, NickChangePayload{Old: "b0t", New: "b0t|away"}, res.Payload)
+
+	res = Parse(":irc.rizon.club 730 ownadi :b0t!b@h,other!o@h")
+	assert.Equal(t, RplMonOnline, res.Type)
+	assert.Equal(t, []string{"b0t", "other"}, res.Payload)
+
+	res = Parse(":irc.rizon.club 731 ownadi :b0t")
+	assert.Equal(t, RplMonOffline, res.Type)
+	assert.Equal(t, []string{"b0t"}, res.Payload)
+
+	res = Parse(":irc.rizon.club 303 ownadi :")
+	assert.Equal(t, RplIson, res.Type)
+	assert.Equal(t, []string{}, res.Payload)
+}
+
 func TestUnknownOnRandomInput(t *testing.T) {
 	res := Parse("FOO BAR BAZ")
 
@@ -79,6 +121,149 @@ func TestPrivMsgDccSendWithSpaces(t *testing.T) {
 	assert.Equal(t, "127.0.0.1", payload.IP.String())
 }
 
+func TestPrivMsgDccSendAddresses(t *testing.T) {
+	tests := []struct {
+		offer    string
+		fileName string
+		ip       string
+		host     string
+		address  string
+	}{
+		{"\x01DCC SEND Gin.txt 2130706433 39095 339260\x01", "Gin.txt", "127.0.0.1", "", "127.0.0.1:39095"},
+		{"\x01DCC SEND Gin.txt 4294967295 39095 339260\x01", "Gin.txt", "255.255.255.255", "", "255.255.255.255:39095"},
+		{"\x01DCC SEND Gin.txt 192.168.1.2 39095 339260\x01", "Gin.txt", "192.168.1.2", "", "192.168.1.2:39095"},
+		{"\x01DCC SEND Gin.txt 2001:db8::1 39095 339260\x01", "Gin.txt", "2001:db8::1", "", "[2001:db8::1]:39095"},
+		{"\x01DCC SEND Gin.txt ::ffff:10.0.0.1 39095 339260\x01", "Gin.txt", "10.0.0.1", "", "10.0.0.1:39095"},
+		{"\x01DCC SEND Gin.txt xdcc.example.org 39095 339260\x01", "Gin.txt", "<nil>", "xdcc.example.org", "xdcc.example.org:39095"},
+		{"\x01DCC SEND Gin.txt localhost 39095 339260\x01", "Gin.txt", "<nil>", "localhost", "localhost:39095"},
+		{"\x01DCC SEND \"Gin Tama.txt\" 2001:db8::1 39095 339260\x01", "Gin Tama.txt", "2001:db8::1", "", "[2001:db8::1]:39095"},
+		{"\x01DCC SEND Gin Tama.txt bot-1.example.org 39095 339260", "Gin Tama.txt", "<nil>", "bot-1.example.org", "bot-1.example.org:39095"},
+	}
+
+	for _, test := range tests {
+		res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :" + test.offer)
+
+		assert.Equal(t, PrivMsgDccSend, res.Type, test.offer)
+		payload, _ := res.Payload.(PrivMsgDccSendPayload)
+		assert.Equal(t, test.fileName, payload.FileName, test.offer)
+		assert.Equal(t, test.ip, payload.IP.String(), test.offer)
+		assert.Equal(t, test.host, payload.Host, test.offer)
+		assert.Equal(t, uint64(39095), payload.Port, test.offer)
+		assert.Equal(t, int64(339260), payload.FileLength, test.offer)
+		assert.Equal(t, test.address, payload.Address(), test.offer)
+	}
+}
+
+func TestPrivMsgDccSendPassive(t *testing.T) {
+	tests := []struct {
+		offer    string
+		fileName string
+		token    string
+	}{
+		{"\x01DCC SEND Gin.txt 2130706433 0 339260 42\x01", "Gin.txt", "42"},
+		{"\x01DCC SEND Gin Tama.txt 2130706433 0 339260 7\x01", "Gin Tama.txt", "7"},
+		{"\x01DCC SEND \"Gin Tama.txt\" 2001:db8::1 0 339260 7\x01", "Gin Tama.txt", "7"},
+		{"\x01DCC SEND 0 2130706433 0 339260 7\x01", "0", "7"},
+	}
+
+	for _, test := range tests {
+		res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :" + test.offer)
+
+		assert.Equal(t, PrivMsgDccSend, res.Type, test.offer)
+		payload, _ := res.Payload.(PrivMsgDccSendPayload)
+		assert.Equal(t, test.fileName, payload.FileName, test.offer)
+		assert.Equal(t, test.token, payload.Token, test.offer)
+		assert.Equal(t, int64(339260), payload.FileLength, test.offer)
+		assert.True(t, payload.Passive(), test.offer)
+	}
+
+	res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :\x01DCC SEND Gin.txt 2130706433 39095 339260\x01")
+	assert.False(t, res.Payload.(PrivMsgDccSendPayload).Passive())
+}
+
+func TestPrivMsgDccSendWithToken(t *testing.T) {
+	tests := []struct {
+		offer    string
+		fileName string
+		port     uint64
+		size     int64
+		token    string
+	}{
+		{"\x01DCC SEND Gin.txt 3232235777 1337 1000 55\x01", "Gin.txt", 1337, 1000, "55"},
+		{"\x01DCC SEND Gin Tama.txt bot.example.org 1337 1000 55\x01", "Gin Tama.txt", 1337, 1000, "55"},
+		{"\x01DCC SEND Gin Tama 12 3232235777 1337 1000\x01", "Gin Tama 12", 1337, 1000, ""},
+		{"\x01DCC SEND Gin 3232235777 3232235777 1337 1000\x01", "Gin 3232235777", 1337, 1000, ""},
+	}
+
+	for _, test := range tests {
+		res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :" + test.offer)
+
+		assert.Equal(t, PrivMsgDccSend, res.Type, test.offer)
+		payload, _ := res.Payload.(PrivMsgDccSendPayload)
+		assert.Equal(t, test.fileName, payload.FileName, test.offer)
+		assert.Equal(t, test.port, payload.Port, test.offer)
+		assert.Equal(t, test.size, payload.FileLength, test.offer)
+		assert.Equal(t, test.token, payload.Token, test.offer)
+	}
+}
+
+func TestPrivMsgDccSsend(t *testing.T) {
+	res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :\x01DCC SSEND \"Gin Tama.txt\" 2130706433 39095 339260\x01")
+
+	assert.Equal(t, PrivMsgDccSend, res.Type)
+	payload := res.Payload.(PrivMsgDccSendPayload)
+	assert.Equal(t, "Gin Tama.txt", payload.FileName)
+	assert.Equal(t, "127.0.0.1:39095", payload.Address())
+	assert.True(t, payload.Secure)
+
+	res = Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :\x01DCC SEND Gin.txt 2130706433 39095 339260\x01")
+	assert.False(t, res.Payload.(PrivMsgDccSendPayload).Secure)
+}
+
+func TestPrivMsgDccSendFileNames(t *testing.T) {
+	tests := []struct {
+		offer    string
+		fileName string
+	}{
+		{`"Gin \"Tama\".mkv"`, `Gin "Tama".mkv`},
+		{`"Gin\\Tama.mkv"`, `Gin\Tama.mkv`},
+		{`"Gin\Tama.mkv"`, `Gin\Tama.mkv`},
+		{`"銀魂 第1話.mkv"`, "銀魂 第1話.mkv"},
+		{`Ginpachi-sensei Café.mkv`, "Ginpachi-sensei Café.mkv"},
+		{`""Gin.mkv"`, ""},
+	}
+
+	for _, test := range tests {
+		res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :\x01DCC SEND " + test.offer + " 2130706433 39095 339260\x01")
+
+		if test.fileName == "" {
+			assert.Equal(t, Unknown, res.Type, test.offer)
+			continue
+		}
+		assert.Equal(t, PrivMsgDccSend, res.Type, test.offer)
+		assert.Equal(t, test.fileName, res.Payload.(PrivMsgDccSendPayload).FileName, test.offer)
+	}
+}
+
+func TestPrivMsgDccSendInvalidAddresses(t *testing.T) {
+	offers := []string{
+		"\x01DCC SEND Gin.txt 4294967296 39095 339260\x01",
+		"\x01DCC SEND Gin.txt 99999999999999999999999 39095 339260\x01",
+		"\x01DCC SEND Gin.txt -bot.example.org 39095 339260\x01",
+		"\x01DCC SEND Gin.txt bot_1.example.org 39095 339260\x01",
+		"\x01DCC SEND Gin.txt 2001:db8::g 39095 339260\x01",
+		"\x01DCC SEND Gin.txt 127.0.0.1 70000 339260\x01",
+		"\x01DCC SEND \"Gin.txt 127.0.0.1 39095 339260\x01",
+		"\x01DCC SEND 127.0.0.1 39095 339260\x01",
+	}
+
+	for _, offer := range offers {
+		res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :" + offer)
+
+		assert.Equal(t, Unknown, res.Type, offer)
+	}
+}
+
 func TestPrivMsgDccSendBroken(t *testing.T) {
 	res := Parse(":[C-W]Archive!~sakura@distro.cartoon-world.org PRIVMSG av1vfca :\x01DCC SEND \"Great Teacher Onizuka - 25 [x264-AC3-DVD][Sakura][C-W][B9F96CF8].mkv\" 213070foo bar baz|")
 
@@ -88,6 +273,40 @@ func TestPrivMsgDccSendBroken(t *testing.T) {
 func TestRandomPrivMsg(t *testing.T) {
 	res := Parse(":[C-W]Archive!~sakura@distro.cartoon-world.org PRIVMSG av1vfca :Hello!")
 
+	assert.Equal(t, PrivMsg, res.Type)
+	assert.Equal(t, TextPayload{Nick: "[C-W]Archive", Target: "av1vfca", Text: "Hello!"}, res.Payload)
+}
+
+func TestNotice(t *testing.T) {
+	res := Parse(":[C-W]Archive!~sakura@distro.cartoon-world.org NOTICE av1vfca :** Invalid Pack Number, Try Again")
+
+	assert.Equal(t, Notice, res.Type)
+	assert.Equal(t, TextPayload{Nick: "[C-W]Archive", Target: "av1vfca", Text: "** Invalid Pack Number, Try Again"}, res.Payload)
+}
+
+func TestCtcpReplyNotice(t *testing.T) {
+	res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei NOTICE ownadi :\x01VERSION mIRC\x01")
+
+	assert.Equal(t, Unknown, res.Type)
+}
+
+func TestPrivMsgCtcp(t *testing.T) {
+	res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :\x01ping 123 456\x01")
+
+	assert.Equal(t, PrivMsgCtcp, res.Type)
+	assert.Equal(t, CTCPPayload{Nick: "Gintoki", Command: "PING", Params: "123 456"}, res.Payload)
+}
+
+func TestPrivMsgCtcpWithoutClosingDelimiter(t *testing.T) {
+	res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :\x01VERSION")
+
+	assert.Equal(t, PrivMsgCtcp, res.Type)
+	assert.Equal(t, CTCPPayload{Nick: "Gintoki", Command: "VERSION"}, res.Payload)
+}
+
+func TestPrivMsgCtcpEmpty(t *testing.T) {
+	res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei PRIVMSG ownadi :\x01\x01")
+
 	assert.Equal(t, Unknown, res.Type)
 }
 
@@ -97,3 +316,57 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, Ping, res.Type)
 	assert.Equal(t, "bar", res.Payload)
 }
+
+func TestCap(t *testing.T) {
+	res := Parse(":irc.rizon.club CAP * LS * :multi-prefix sasl=PLAIN,EXTERNAL")
+
+	assert.Equal(t, Cap, res.Type)
+	assert.Equal(t, CapPayload{Subcommand: "LS", Capabilities: []string{"multi-prefix", "sasl=PLAIN,EXTERNAL"}, More: true}, res.Payload)
+
+	res = Parse(":irc.rizon.club CAP ownadi ACK :sasl")
+
+	assert.Equal(t, CapPayload{Subcommand: "ACK", Capabilities: []string{"sasl"}}, res.Payload)
+}
+
+func TestAuthenticate(t *testing.T) {
+	res := Parse("AUTHENTICATE +")
+
+	assert.Equal(t, Authenticate, res.Type)
+	assert.Equal(t, "+", res.Payload)
+}
+
+func TestSaslReplies(t *testing.T) {
+	res := Parse(":irc.rizon.club 900 ownadi ownadi!u@h acc :You are now logged in as acc")
+	assert.Equal(t, RplLoggedIn, res.Type)
+	assert.Equal(t, "acc", res.Payload)
+
+	res = Parse(":irc.rizon.club 903 ownadi :SASL authentication successful")
+	assert.Equal(t, RplSaslSuccess, res.Type)
+
+	res = Parse(":irc.rizon.club 904 ownadi :SASL authentication failed")
+	assert.Equal(t, ErrSaslFail, res.Type)
+	assert.Equal(t, "904", res.Payload)
+}
+
+func TestPacketKeepsMessage(t *testing.T) {
+	res := Parse(":Gintoki!~Gin@oshiete.ginpachi.sensei NOTICE ownadi :Queued")
+
+	assert.Equal(t, Notice, res.Type)
+	assert.Equal(t, "NOTICE", res.Message.Command)
+	assert.Equal(t, "Gintoki", res.Message.Prefix.Name)
+	assert.Equal(t, ":Gintoki!~Gin@oshiete.ginpachi.sensei NOTICE ownadi :Queued", res.Message.Raw)
+}
+
+func TestRplEndOfNamesWithoutChannel(t *testing.T) {
+	res := Parse(":irc.rizon.club 366 gharibol")
+
+	assert.Equal(t, Unknown, res.Type)
+}
+
+func TestRplWhoisChannelsWithMembershipPrefixes(t *testing.T) {
+	res := Parse(":magnet.rizon.net 319 foo Ginpachi-Sensei :@#HorribleSubs +#NIBL #news")
+
+	payload, ok := res.Payload.(RplWhoisChannelsPayload)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"HorribleSubs", "NIBL", "news"}, payload.channels)
+}
@@ -0,0 +1,177 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const presencePollMsec = 30000
+
+// isonBatch limits nicks per ISON so that queries fit into a line.
+const isonBatch = 20
+
+// PresencePayload reports a watched nick going offline or coming online.
+// NewNick is set when the user went offline by changing nick.
+type PresencePayload struct {
+	Nick    string
+	Online  bool
+	NewNick string
+}
+
+// presence tracks watched nicks, keyed by lower-cased nick.
+type presence struct {
+	// monitor is set when the server supports MONITOR. ISON is polled otherwise.
+	monitor bool
+	watched map[string]string
+	// online keeps the last known state. Nicks of unknown state are missing.
+	online map[string]bool
+	// isonQueries are nicks of sent ISON queries waiting for replies, in order.
+	isonQueries [][]string
+}
+
+// Watch starts tracking presence of the nick. Changes are sent as Presence packets
+// on IRCPacketsChann. Uses MONITOR where the server supports it and polls ISON
+// every PresencePollMsec otherwise. QUIT and NICK of the nick are followed either way.
+func (e *Engine) Watch(nick string) {
+	key := strings.ToLower(nick)
+
+	e.presenceMutex.Lock()
+	defer e.presenceMutex.Unlock()
+
+	if _, watched := e.presence.watched[key]; watched {
+		return
+	}
+	e.presence.watched[key] = nick
+	if e.presence.monitor {
+		e.send("MONITOR + " + nick)
+	}
+}
+
+// Unwatch stops tracking presence of the nick.
+func (e *Engine) Unwatch(nick string) {
+	key := strings.ToLower(nick)
+
+	e.presenceMutex.Lock()
+	defer e.presenceMutex.Unlock()
+
+	if _, watched := e.presence.watched[key]; !watched {
+		return
+	}
+	delete(e.presence.watched, key)
+	delete(e.presence.online, key)
+	if e.presence.monitor {
+		e.send("MONITOR - " + nick)
+	}
+}
+
+func (e *Engine) presencePoll() time.Duration {
+	if e.PresencePollMsec > 0 {
+		return time.Duration(e.PresencePollMsec) * time.Millisecond
+	}
+
+	return presencePollMsec * time.Millisecond
+}
+
+// pollPresence queries ISON for watched nicks until the engine stops, unless MONITOR is supported.
+func (e *Engine) pollPresence() {
+	for {
+		select {
+		case <-time.After(e.presencePoll()):
+		case <-e.ctx.Done():
+			return
+		}
+
+		e.presenceMutex.Lock()
+		if !e.presence.monitor {
+			nicks := e.watchedNicks()
+			for start := 0; start < len(nicks); start += isonBatch {
+				end := start + isonBatch
+				if end > len(nicks) {
+					end = len(nicks)
+				}
+				e.presence.isonQueries = append(e.presence.isonQueries, nicks[start:end])
+				e.send("ISON " + strings.Join(nicks[start:end], " "))
+			}
+		}
+		e.presenceMutex.Unlock()
+	}
+}
+
+// watchedNicks returns watched nicks sorted. Has to be called with presenceMutex held.
+func (e *Engine) watchedNicks() []string {
+	nicks := make([]string, 0, len(e.presence.watched))
+	for _, nick := range e.presence.watched {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+
+	return nicks
+}
+
+// trackPresence updates presence of watched nicks and emits Presence packets on changes.
+// Like membership, it depends on order of lines.
+func (e *Engine) trackPresence(packet Packet) {
+	m := packet.Message
+	changes := []PresencePayload{}
+
+	e.presenceMutex.Lock()
+	switch {
+	case m.Command == rplISupport:
+		for i := 1; i < m.ParamsCount()-1; i++ {
+			if m.Param(i) == "MONITOR" || strings.HasPrefix(m.Param(i), "MONITOR=") {
+				e.presence.monitor = true
+				e.presence.isonQueries = nil
+				if nicks := e.watchedNicks(); len(nicks) > 0 {
+					e.send("MONITOR + " + strings.Join(nicks, ","))
+				}
+			}
+		}
+	case m.Command == errMonListFull:
+		e.presence.monitor = false
+	case packet.Type == Quit:
+		changes = e.setOnline(changes, packet.Payload.(string), false, "")
+	case packet.Type == NickChange:
+		payload := packet.Payload.(NickChangePayload)
+		changes = e.setOnline(changes, payload.Old, false, payload.New)
+		changes = e.setOnline(changes, payload.New, true, "")
+	case packet.Type == RplMonOnline || packet.Type == RplMonOffline:
+		for _, nick := range packet.Payload.([]string) {
+			changes = e.setOnline(changes, nick, packet.Type == RplMonOnline, "")
+		}
+	case packet.Type == RplIson && len(e.presence.isonQueries) > 0:
+		online := map[string]bool{}
+		for _, nick := range packet.Payload.([]string) {
+			online[strings.ToLower(nick)] = true
+		}
+		for _, nick := range e.presence.isonQueries[0] {
+			changes = e.setOnline(changes, nick, online[strings.ToLower(nick)], "")
+		}
+		e.presence.isonQueries = e.presence.isonQueries[1:]
+	}
+	e.presenceMutex.Unlock()
+
+	if len(changes) > 0 {
+		go func() {
+			for _, change := range changes {
+				e.emit(Packet{Type: Presence, Payload: change, Message: m})
+			}
+		}()
+	}
+}
+
+// setOnline records state of a watched nick and appends it to changes when it differs
+// from the known one. Has to be called with presenceMutex held.
+func (e *Engine) setOnline(changes []PresencePayload, nick string, online bool, newNick string) []PresencePayload {
+	key := strings.ToLower(nick)
+	if _, watched := e.presence.watched[key]; !watched {
+		return changes
+	}
+
+	if known, ok := e.presence.online[key]; ok && known == online && newNick == "" {
+		return changes
+	}
+	e.presence.online[key] = online
+
+	return append(changes, PresencePayload{Nick: e.presence.watched[key], Online: online, NewNick: newNick})
+}
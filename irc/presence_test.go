@@ -0,0 +1,91 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func nextPresence(engine *Engine) PresencePayload {
+	for packet := range engine.IRCPacketsChann() {
+		if packet.Type == Presence {
+			return packet.Payload.(PresencePayload)
+		}
+	}
+
+	return PresencePayload{}
+}
+
+func TestWatchWithMonitor(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	engine.Watch("b0t")
+	client.Write([]byte(":irc.rizon.club 005 ownadi NICKLEN=30 MONITOR=100 :are supported by this server\r\n"))
+
+	scanner.Scan()
+	assert.Equal(t, "MONITOR + b0t", scanner.Text())
+
+	engine.Watch("other")
+	scanner.Scan()
+	assert.Equal(t, "MONITOR + other", scanner.Text())
+
+	client.Write([]byte(":irc.rizon.club 731 ownadi :b0t\r\n"))
+	assert.Equal(t, PresencePayload{Nick: "b0t", Online: false}, nextPresence(engine))
+
+	client.Write([]byte(":irc.rizon.club 730 ownadi :B0T!b@h\r\n"))
+	assert.Equal(t, PresencePayload{Nick: "b0t", Online: true}, nextPresence(engine))
+
+	engine.Unwatch("other")
+	scanner.Scan()
+	assert.Equal(t, "MONITOR - other", scanner.Text())
+}
+
+func TestWatchWithIson(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{PresencePollMsec: 10}
+	engine.Start(server)
+	defer engine.Stop()
+	go engine.pollPresence()
+
+	engine.Watch("b0t")
+	engine.Watch("other")
+
+	scanner.Scan()
+	assert.Equal(t, "ISON b0t other", scanner.Text())
+	client.Write([]byte(":irc.rizon.club 303 ownadi :other\r\n"))
+
+	assert.Equal(t, PresencePayload{Nick: "b0t", Online: false}, nextPresence(engine))
+	assert.Equal(t, PresencePayload{Nick: "other", Online: true}, nextPresence(engine))
+
+	scanner.Scan()
+	client.Write([]byte(":irc.rizon.club 303 ownadi :other b0t\r\n"))
+
+	assert.Equal(t, PresencePayload{Nick: "b0t", Online: true}, nextPresence(engine))
+}
+
+func TestWatchFollowsQuitAndNick(t *testing.T) {
+	client, server := net.Pipe()
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	engine.Watch("b0t")
+	engine.Watch("other")
+
+	client.Write([]byte(":b0t!b@h NICK :b0t|away\r\n"))
+	assert.Equal(t, PresencePayload{Nick: "b0t", Online: false, NewNick: "b0t|away"}, nextPresence(engine))
+
+	client.Write([]byte(":stranger!s@h QUIT :bye\r\n"))
+	client.Write([]byte(":other!o@h QUIT :Ping timeout\r\n"))
+	assert.Equal(t, PresencePayload{Nick: "other", Online: false}, nextPresence(engine))
+}
@@ -0,0 +1,257 @@
+package irc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const floodIntervalMsec = 2000
+
+// sendQueue keeps lines waiting to be written. Priority lines go out before normal ones.
+type sendQueue struct {
+	mutex    *sync.Mutex
+	priority []string
+	normal   []string
+	notify   chan struct{}
+	// Lines pushed and written so far in each lane, to tell when a flush is done.
+	pushed  lanes
+	written lanes
+	flushes []flush
+	stopped bool
+}
+
+type lanes struct {
+	priority int
+	normal   int
+}
+
+// flush is waiting for lines pushed in each lane to be written.
+type flush struct {
+	lanes
+	done chan struct{}
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{mutex: &sync.Mutex{}, notify: make(chan struct{}, 1)}
+}
+
+func (q *sendQueue) push(line string, priority bool) {
+	q.mutex.Lock()
+	if priority {
+		q.priority = append(q.priority, line)
+		q.pushed.priority++
+	} else {
+		q.normal = append(q.normal, line)
+		q.pushed.normal++
+	}
+	q.mutex.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// popPriority returns the first priority line, if there is one.
+func (q *sendQueue) popPriority() (string, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.priority) == 0 {
+		return "", false
+	}
+	line := q.priority[0]
+	q.priority = q.priority[1:]
+
+	return line, true
+}
+
+// popNormal returns the first normal line, if there is one.
+func (q *sendQueue) popNormal() (string, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.normal) == 0 {
+		return "", false
+	}
+	line := q.normal[0]
+	q.normal = q.normal[1:]
+
+	return line, true
+}
+
+func (q *sendQueue) hasNormal() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.normal) > 0
+}
+
+// flushed returns a channel closed once all lines pushed so far are written
+// or the queue is stopped.
+func (q *sendQueue) flushed() <-chan struct{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	done := make(chan struct{})
+	if q.stopped || q.written == q.pushed {
+		close(done)
+	} else {
+		q.flushes = append(q.flushes, flush{lanes: q.pushed, done: done})
+	}
+
+	return done
+}
+
+// markWritten counts a line as written and closes flushes it completes.
+func (q *sendQueue) markWritten(priority bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if priority {
+		q.written.priority++
+	} else {
+		q.written.normal++
+	}
+
+	pending := q.flushes[:0]
+	for _, f := range q.flushes {
+		if q.written.priority >= f.priority && q.written.normal >= f.normal {
+			close(f.done)
+		} else {
+			pending = append(pending, f)
+		}
+	}
+	q.flushes = pending
+}
+
+// stop closes all pending flushes, as their lines are never going to be written.
+func (q *sendQueue) stop() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.stopped = true
+	for _, f := range q.flushes {
+		close(f.done)
+	}
+	q.flushes = nil
+}
+
+func (q *sendQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.priority) + len(q.normal)
+}
+
+// tokenBucket allows burst lines at once and then one line per interval.
+// Zero burst means no limit.
+type tokenBucket struct {
+	burst    float64
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(burst int, interval time.Duration, now time.Time) *tokenBucket {
+	return &tokenBucket{burst: float64(burst), interval: interval, tokens: float64(burst), last: now}
+}
+
+// wait returns how long to wait for a token.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if b.burst <= 0 {
+		return 0
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) * float64(b.interval))
+}
+
+// spend consumes a token, if there is one.
+func (b *tokenBucket) spend() {
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		b.tokens = 0
+	}
+}
+
+// QueueLength returns number of lines waiting to be sent.
+func (e *Engine) QueueLength() int {
+	return e.sendQueue.len()
+}
+
+// Flushed returns a channel closed once all lines queued so far are written,
+// or the engine stops. Replies to queued lines cannot come before.
+func (e *Engine) Flushed() <-chan struct{} {
+	return e.sendQueue.flushed()
+}
+
+func (e *Engine) floodInterval() time.Duration {
+	if e.FloodIntervalMsec > 0 {
+		return time.Duration(e.FloodIntervalMsec) * time.Millisecond
+	}
+
+	return floodIntervalMsec * time.Millisecond
+}
+
+// send queues a line. Lines are written in order, within the flood limit.
+func (e *Engine) send(data string) {
+	e.sendQueue.push(data, false)
+}
+
+// sendPriority queues a line ahead of all normal ones. It is not delayed by the flood limit
+// but still counts towards it.
+func (e *Engine) sendPriority(data string) {
+	e.sendQueue.push(data, true)
+}
+
+// writeQueued writes queued lines to the stream until the engine stops.
+func (e *Engine) writeQueued() {
+	defer e.sendQueue.stop()
+
+	bucket := newTokenBucket(e.FloodBurst, e.floodInterval(), time.Now())
+
+	for {
+		line, ok := e.sendQueue.popPriority()
+		priority := ok
+
+		var timer <-chan time.Time
+		if !ok && e.sendQueue.hasNormal() {
+			if wait := bucket.wait(time.Now()); wait > 0 {
+				timer = time.After(wait)
+			} else {
+				line, ok = e.sendQueue.popNormal()
+			}
+		}
+
+		if !ok {
+			select {
+			case <-e.sendQueue.notify:
+			case <-timer:
+			case <-e.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		bucket.wait(time.Now())
+		bucket.spend()
+		_, err := fmt.Fprintf(e.ircStream, "%s\r\n", line)
+		if err != nil {
+			e.cancelFunc()
+			return
+		}
+		e.sendQueue.markWritten(priority)
+	}
+}
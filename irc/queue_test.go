@@ -0,0 +1,152 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendKeepsOrder(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	engine.SendMessage("Gintoki", "foo")
+	engine.SendMessage("Gintoki", "bar")
+	engine.SendMessage("Gintoki", "baz")
+
+	for _, body := range []string{"foo", "bar", "baz"} {
+		scanner.Scan()
+		assert.Equal(t, "PRIVMSG Gintoki :"+body, scanner.Text())
+	}
+}
+
+func TestSendFloodLimit(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{FloodBurst: 2, FloodIntervalMsec: 100}
+	engine.Start(server)
+	defer engine.Stop()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		engine.SendMessage("Gintoki", "foo")
+	}
+
+	scanner.Scan()
+	scanner.Scan()
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+
+	scanner.Scan()
+	scanner.Scan()
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+}
+
+func TestPongHasPriority(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{FloodBurst: 1, FloodIntervalMsec: 60000}
+	engine.Start(server)
+	defer engine.Stop()
+
+	engine.SendMessage("Gintoki", "foo")
+	scanner.Scan()
+	assert.Equal(t, "PRIVMSG Gintoki :foo", scanner.Text())
+
+	engine.SendMessage("Gintoki", "bar")
+	engine.SendMessage("Gintoki", "baz")
+	go client.Write([]byte("PING :irc.rizon.net\r\n"))
+
+	scanner.Scan()
+	assert.Equal(t, "PONG :irc.rizon.net", scanner.Text())
+	assert.Equal(t, 2, engine.QueueLength())
+}
+
+func TestQueueLength(t *testing.T) {
+	_, server := net.Pipe()
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	engine.SendMessage("Gintoki", "foo")
+	engine.SendMessage("Gintoki", "bar")
+	engine.SendMessage("Gintoki", "baz")
+
+	// The first line is blocked on write as nobody reads the other end.
+	assert.Eventually(t, func() bool { return engine.QueueLength() == 2 }, time.Second, time.Millisecond)
+}
+
+func TestFlushed(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{FloodBurst: 1, FloodIntervalMsec: 60000}
+	engine.Start(server)
+	defer engine.Stop()
+
+	engine.SendMessage("Gintoki", "foo")
+	engine.SendMessage("Gintoki", "bar")
+	flushed := engine.Flushed()
+
+	scanner.Scan()
+	select {
+	case <-flushed:
+		t.Fatal("flushed before lines are written")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	engine.Stop()
+	assert.Eventually(t, func() bool {
+		select {
+		case <-flushed:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+}
+
+func TestFlushedOnceWritten(t *testing.T) {
+	client, server := net.Pipe()
+	scanner := bufio.NewScanner(client)
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	engine.SendMessage("Gintoki", "foo")
+	flushed := engine.Flushed()
+	scanner.Scan()
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("not flushed")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(2, time.Second, now)
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, time.Duration(0), bucket.wait(now))
+		bucket.spend()
+	}
+	assert.Equal(t, time.Second, bucket.wait(now))
+	assert.Equal(t, 500*time.Millisecond, bucket.wait(now.Add(500*time.Millisecond)))
+	assert.Equal(t, time.Duration(0), bucket.wait(now.Add(time.Second)))
+
+	unlimited := newTokenBucket(0, time.Second, now)
+	unlimited.spend()
+	assert.Equal(t, time.Duration(0), unlimited.wait(now))
+}
@@ -0,0 +1,94 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+)
+
+const subscriptionBuffer = 16
+
+// Filter selects packets a subscription receives.
+type Filter func(Packet) bool
+
+// OfType matches packets of any of given types.
+func OfType(types ...PacketType) Filter {
+	return func(packet Packet) bool {
+		for _, packetType := range types {
+			if packet.Type == packetType {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// OfCommand matches messages of any of given commands, e.g. "JOIN" or "366",
+// whether they are recognized as packets or not.
+func OfCommand(commands ...string) Filter {
+	return func(packet Packet) bool {
+		for _, command := range commands {
+			if strings.EqualFold(packet.Message.Command, command) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+type subscriptions struct {
+	mutex   *sync.RWMutex
+	last    int
+	filters map[int]Filter
+	packets map[int]chan Packet
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{mutex: &sync.RWMutex{}, filters: map[int]Filter{}, packets: map[int]chan Packet{}}
+}
+
+// dispatch sends the packet to all matching subscriptions. Subscribers which
+// do not keep up miss packets instead of blocking others.
+func (s *subscriptions) dispatch(packet Packet) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for id, filter := range s.filters {
+		if !filter(packet) {
+			continue
+		}
+
+		select {
+		case s.packets[id] <- packet:
+		default:
+		}
+	}
+}
+
+// Subscribe sends received packets matching the filter, including Unknown ones,
+// on the returned channel until unsubscribe gets called. Unsubscribe closes the channel.
+func (e *Engine) Subscribe(filter Filter) (<-chan Packet, func()) {
+	s := e.subscriptions
+	packets := make(chan Packet, subscriptionBuffer)
+
+	s.mutex.Lock()
+	s.last++
+	id := s.last
+	s.filters[id] = filter
+	s.packets[id] = packets
+	s.mutex.Unlock()
+
+	once := &sync.Once{}
+	unsubscribe := func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			delete(s.filters, id)
+			delete(s.packets, id)
+			close(packets)
+			s.mutex.Unlock()
+		})
+	}
+
+	return packets, unsubscribe
+}
@@ -0,0 +1,104 @@
+package irc
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeOfType(t *testing.T) {
+	client, server := net.Pipe()
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	packets, unsubscribe := engine.Subscribe(OfType(RplEndOfNames))
+	defer unsubscribe()
+
+	client.Write([]byte(":irc.rizon.club 001 gharibol :Welcome\r\n"))
+	client.Write([]byte(":irc.rizon.club 366 gharibol #foo :End of /NAMES list.\r\n"))
+
+	packet := <-packets
+	assert.Equal(t, RplEndOfNames, packet.Type)
+	assert.Equal(t, "foo", packet.Payload)
+}
+
+func TestSubscribeKeepsOrderOfLines(t *testing.T) {
+	client, server := net.Pipe()
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	packets, unsubscribe := engine.Subscribe(OfType(RplEndOfNames))
+	defer unsubscribe()
+
+	lines := ""
+	for i := 0; i < 10; i++ {
+		lines += fmt.Sprintf(":irc.rizon.club 366 gharibol #foo%d :End of /NAMES list.\r\n", i)
+	}
+	client.Write([]byte(lines))
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, fmt.Sprintf("foo%d", i), (<-packets).Payload)
+	}
+}
+
+func TestSubscribeOfCommandGetsUnknownPackets(t *testing.T) {
+	client, server := net.Pipe()
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	packets, unsubscribe := engine.Subscribe(OfCommand("join"))
+	defer unsubscribe()
+
+	client.Write([]byte(":gharibol!~g@h JOIN #foo\r\n"))
+
+	packet := <-packets
+	assert.Equal(t, Unknown, packet.Type)
+	assert.Equal(t, "#foo", packet.Message.Param(0))
+}
+
+func TestSubscribeWithPredicate(t *testing.T) {
+	client, server := net.Pipe()
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	bar, unsubscribeBar := engine.Subscribe(func(packet Packet) bool {
+		return packet.Type == RplEndOfNames && packet.Payload == "bar"
+	})
+	defer unsubscribeBar()
+	all, unsubscribeAll := engine.Subscribe(OfType(RplEndOfNames))
+	defer unsubscribeAll()
+
+	client.Write([]byte(":irc.rizon.club 366 gharibol #foo :End of /NAMES list.\r\n"))
+	assert.Equal(t, "foo", (<-all).Payload)
+
+	client.Write([]byte(":irc.rizon.club 366 gharibol #bar :End of /NAMES list.\r\n"))
+	assert.Equal(t, "bar", (<-bar).Payload)
+	assert.Equal(t, "bar", (<-all).Payload)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	client, server := net.Pipe()
+
+	engine := &Engine{}
+	engine.Start(server)
+	defer engine.Stop()
+
+	packets, unsubscribe := engine.Subscribe(OfType(RplEndOfNames))
+	unsubscribe()
+	unsubscribe()
+
+	client.Write([]byte(":irc.rizon.club 366 gharibol #foo :End of /NAMES list.\r\n"))
+
+	_, open := <-packets
+	assert.False(t, open)
+}
@@ -1,10 +1,151 @@
 package main
 
 import (
+	"animuxd/config"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
 	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
+
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run dispatches given arguments to a subcommand and returns an exit code.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) >= 2 && args[0] == "config" && args[1] == "check" {
+		return configCheck(args[2:], stdout, stderr)
+	}
+	if len(args) >= 1 {
+		switch args[0] {
+		case "add":
+			return add(args[1:], stdout, stderr)
+		case "list":
+			return list(args[1:], stdout, stderr)
+		case "watch":
+			return watch(args[1:], stdout, stderr)
+		case "status":
+			return status(args[1:], stdout, stderr)
+		}
+	}
+
+	return serve(args, stderr)
+}
+
+// loadConfig parses flags shared by all subcommands that need configuration.
+// Explicitly set flags take precedence over both the file and the environment.
+func loadConfig(name string, args []string, stderr io.Writer) (config.Config, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	configPath := flags.String("config", os.Getenv("ANIMUXD_CONFIG"), "path to configuration file")
+	server := flags.String("server", "", "address of IRC server of the default network")
+	listen := flags.String("listen", "", "address the HTTP API listens on")
+	downloadDir := flags.String("dir", "", "directory downloaded files are stored in")
+	unsafe := flags.Bool("unsafe", false, "accept DCC offers of files that were not requested")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return cfg, err
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			if len(cfg.Networks) > 0 {
+				cfg.Networks[0].Server = *server
+			}
+		case "listen":
+			cfg.API.Listen = *listen
+		case "dir":
+			cfg.Paths.DownloadDir = *downloadDir
+		case "unsafe":
+			cfg.Unsafe = *unsafe
+		}
+	})
+
+	return cfg, nil
+}
+
+func serve(args []string, stderr io.Writer) int {
+	logger := log.New(stderr, "", log.LstdFlags)
+
+	cfg, err := loadConfig("animuxd", args, stderr)
+	if err != nil {
+		logger.Print(err)
+		return 2
+	}
+
+	if errs := cfg.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			logger.Print(err)
+		}
+		return 2
+	}
+
+	d := &daemon{}
+	err = d.Start(cfg)
+	if err != nil {
+		logger.Print(err)
+		return 1
+	}
+	for _, network := range cfg.Networks {
+		logger.Printf("Registered on %s as %s", network.Name, d.Nick(network.Name))
+	}
+	logger.Printf("Listening on %s", d.Addr())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	logger.Print("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeouts.ShutdownGraceMsec)*time.Millisecond)
+	defer cancel()
+
+	err = d.Shutdown(ctx)
+	if err != nil {
+		logger.Print(err)
+		return 1
+	}
+
+	return 0
+}
+
+// configCheck prints resolved configuration and its validation errors.
+func configCheck(args []string, stdout io.Writer, stderr io.Writer) int {
+	cfg, err := loadConfig("animuxd config check", args, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	err = cfg.Write(stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	errs := cfg.Validate()
+	for _, err := range errs {
+		fmt.Fprintf(stderr, "invalid %v\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+
+	return 0
 }
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"animuxd/config"
+	"animuxd/supervisor"
+	"animuxd/xdcc"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var fakeNickPattern = regexp.MustCompile("^NICK (\\S*)$")
+var fakeWhoisPattern = regexp.MustCompile("^WHOIS (\\S*)$")
+var fakeJoinPattern = regexp.MustCompile("^JOIN #(\\S*)$")
+var fakeXdccSendPattern = regexp.MustCompile("^PRIVMSG (\\S*) :XDCC SEND ([0-9]*)$")
+
+// fakeIrcServer is a tiny IRC server with a single bot
+// that offers every requested package as a file with given content.
+type fakeIrcServer struct {
+	listener    net.Listener
+	conns       []net.Conn
+	connsMutex  sync.Mutex
+	fileName    string
+	fileContent string
+}
+
+func (s *fakeIrcServer) Start(fileName string, fileContent string) {
+	s.listener, _ = net.Listen("tcp", "127.0.0.1:0")
+	s.fileName = fileName
+	s.fileContent = fileContent
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			s.connsMutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.connsMutex.Unlock()
+			go s.handle(conn)
+		}
+	}()
+}
+
+func (s *fakeIrcServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeIrcServer) Stop() {
+	s.listener.Close()
+}
+
+// Drop closes all client connections while still accepting new ones.
+func (s *fakeIrcServer) Drop() {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeIrcServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	nick := "*"
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "QUIT") {
+			return
+		}
+
+		if captures := fakeNickPattern.FindStringSubmatch(line); captures != nil {
+			nick = captures[1]
+			fmt.Fprintf(conn, ":fake.irc 001 %s :Welcome\r\n", nick)
+		}
+
+		if captures := fakeWhoisPattern.FindStringSubmatch(line); captures != nil {
+			fmt.Fprintf(
+				conn, ":fake.irc 311 %[1]s %[2]s bot fake.irc * :Bot\r\n"+
+					":fake.irc 319 %[1]s %[2]s :#fake\r\n"+
+					":fake.irc 312 %[1]s %[2]s fake.irc :Fake server\r\n"+
+					":fake.irc 317 %[1]s %[2]s 0 0 :seconds idle, signon time\r\n"+
+					":fake.irc 318 %[1]s %[2]s :End of /WHOIS list.\r\n",
+				nick, captures[1],
+			)
+		}
+
+		if captures := fakeJoinPattern.FindStringSubmatch(line); captures != nil {
+			fmt.Fprintf(conn, ":fake.irc 366 %s #%s :End of /NAMES list.\r\n", nick, captures[1])
+		}
+
+		if captures := fakeXdccSendPattern.FindStringSubmatch(line); captures != nil {
+			port := s.serveFile()
+			fmt.Fprintf(
+				conn, ":%s!bot@fake.irc PRIVMSG %s :\x01DCC SEND %s 2130706433 %d %d\x01\r\n",
+				captures[1], nick, s.fileName, port, len(s.fileContent),
+			)
+		}
+	}
+}
+
+func (s *fakeIrcServer) serveFile() int {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte(s.fileContent))
+		ioutil.ReadAll(conn)
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func testConfig(server string, dir string) config.Config {
+	cfg := config.Default()
+	cfg.Networks = []config.Network{{Name: "fake", Server: server}}
+	cfg.API.Listen = "127.0.0.1:0"
+	cfg.Paths.DownloadDir = dir
+	cfg.Paths.StateFile = filepath.Join(dir, "state.json")
+
+	return cfg
+}
+
+func waitForDownload(t *testing.T, apiAddr string, fileName string) map[string]interface{} {
+	for i := 0; i < 100; i++ {
+		response, err := http.Get(fmt.Sprintf("http://%s/downloads", apiAddr))
+		assert.Nil(t, err)
+
+		var downloads []map[string]interface{}
+		json.NewDecoder(response.Body).Decode(&downloads)
+		response.Body.Close()
+
+		for _, download := range downloads {
+			if download["FileName"] == fileName && (download["Status"] == float64(xdcc.Done) || download["Status"] == float64(xdcc.Failed)) {
+				return download
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatal("Download did not finish")
+	return nil
+}
+
+func TestDaemonDownloadsFile(t *testing.T) {
+	server := &fakeIrcServer{}
+	server.Start("foo.txt", "hello world")
+	defer server.Stop()
+
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+
+	d := &daemon{}
+	err := d.Start(testConfig(server.Addr(), dir))
+	assert.Nil(t, err)
+	defer d.Stop()
+
+	response, err := http.Post(
+		fmt.Sprintf("http://%s/downloads", d.Addr()),
+		"application/json",
+		strings.NewReader(`{"fileName": "foo.txt", "botNick": "b0t", "packageNumber": 1}`),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+
+	download := waitForDownload(t, d.Addr().String(), "foo.txt")
+	assert.Equal(t, float64(xdcc.Done), download["Status"])
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "foo.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(data))
+}
+
+func TestDaemonDownloadsFromSecondNetwork(t *testing.T) {
+	firstServer := &fakeIrcServer{}
+	firstServer.Start("foo.txt", "hello world")
+	defer firstServer.Stop()
+	secondServer := &fakeIrcServer{}
+	secondServer.Start("bar.txt", "hello second world")
+	defer secondServer.Stop()
+
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+
+	d := &daemon{}
+	cfg := testConfig(firstServer.Addr(), dir)
+	cfg.Networks = append(cfg.Networks, config.Network{Name: "second", Server: secondServer.Addr()})
+	err := d.Start(cfg)
+	assert.Nil(t, err)
+	defer d.Stop()
+
+	response, err := http.Post(
+		fmt.Sprintf("http://%s/downloads", d.Addr()),
+		"application/json",
+		strings.NewReader(`{"network": "second", "fileName": "bar.txt", "botNick": "b0t", "packageNumber": 1}`),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+
+	download := waitForDownload(t, d.Addr().String(), "bar.txt")
+	assert.Equal(t, float64(xdcc.Done), download["Status"])
+	assert.Equal(t, "second", download["Network"])
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "bar.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello second world", string(data))
+}
+
+func TestDaemonShutdownSavesState(t *testing.T) {
+	server := &fakeIrcServer{}
+	server.Start("foo.txt", "hello world")
+	defer server.Stop()
+
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+
+	d := &daemon{}
+	err := d.Start(testConfig(server.Addr(), dir))
+	assert.Nil(t, err)
+
+	http.Post(
+		fmt.Sprintf("http://%s/downloads", d.Addr()),
+		"application/json",
+		strings.NewReader(`{"fileName": "foo.txt", "botNick": "b0t", "packageNumber": 1}`),
+	)
+	waitForDownload(t, d.Addr().String(), "foo.txt")
+
+	err = d.Shutdown(context.Background())
+	assert.Nil(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "state.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"FileName":"foo.txt"`)
+	assert.Contains(t, string(data), fmt.Sprintf(`"Status":%d`, xdcc.Done))
+}
+
+func TestDaemonRequestsInterruptedDownloadsOnStart(t *testing.T) {
+	server := &fakeIrcServer{}
+	server.Start("foo.txt", "hello world")
+	defer server.Stop()
+
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(
+		filepath.Join(dir, "state.json"),
+		[]byte(fmt.Sprintf(`[{"FileName":"foo.txt","Status":%d,"BotNick":"b0t","PackageNo":1}]`, xdcc.Interrupted)),
+		0644,
+	)
+
+	d := &daemon{}
+	err := d.Start(testConfig(server.Addr(), dir))
+	assert.Nil(t, err)
+	defer d.Stop()
+
+	download := waitForDownload(t, d.Addr().String(), "foo.txt")
+	assert.Equal(t, float64(xdcc.Done), download["Status"])
+}
+
+func TestDaemonReconnects(t *testing.T) {
+	server := &fakeIrcServer{}
+	server.Start("foo.txt", "hello world")
+	defer server.Stop()
+
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+
+	d := &daemon{}
+	cfg := testConfig(server.Addr(), dir)
+	cfg.Timeouts.ReconnectMinMsec = 10
+	cfg.Timeouts.ReconnectMaxMsec = 20
+	err := d.Start(cfg)
+	assert.Nil(t, err)
+	defer d.Stop()
+
+	server.Drop()
+
+	var status supervisor.Status
+	for i := 0; i < 100 && status.Reconnects == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		response, err := http.Get(fmt.Sprintf("http://%s/status", d.Addr()))
+		assert.Nil(t, err)
+
+		var daemonStatus struct{ Networks []supervisor.Status }
+		json.NewDecoder(response.Body).Decode(&daemonStatus)
+		response.Body.Close()
+		status = daemonStatus.Networks[0]
+	}
+
+	assert.Equal(t, 1, status.Reconnects)
+	assert.True(t, status.Connected)
+	assert.Len(t, status.Attempts, 2)
+}
+
+func TestDaemonServesUI(t *testing.T) {
+	server := &fakeIrcServer{}
+	server.Start("foo.txt", "hello world")
+	defer server.Stop()
+
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+	uiDir := filepath.Join(dir, "ui")
+	os.Mkdir(uiDir, 0755)
+	ioutil.WriteFile(filepath.Join(uiDir, "index.html"), []byte("<html>animuxd</html>"), 0644)
+
+	cfg := testConfig(server.Addr(), dir)
+	cfg.API.UIDir = uiDir
+	cfg.API.CORS = false
+
+	d := &daemon{}
+	err := d.Start(cfg)
+	assert.Nil(t, err)
+	defer d.Stop()
+
+	response, err := http.Get(fmt.Sprintf("http://%s/search", d.Addr()))
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "<html>animuxd</html>", string(body))
+}
+
+func TestDaemonFailsWithoutEmbeddedUI(t *testing.T) {
+	cfg := testConfig("127.0.0.1:1", "")
+	cfg.API.EmbeddedUI = true
+
+	d := &daemon{}
+	err := d.Start(cfg)
+
+	assert.NotNil(t, err)
+}
+
+func TestDaemonFailsOnUnreachableServer(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+
+	d := &daemon{}
+	err := d.Start(testConfig(addr, os.TempDir()))
+	assert.NotNil(t, err)
+}
+
+func TestConfigCheck(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "animuxd.yml")
+	ioutil.WriteFile(configPath, []byte("networks:\n- name: foo\n  server: irc.foo.net:6697\n"), 0644)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"config", "check", "-config", configPath, "-listen", "0.0.0.0:8080"}, stdout, stderr)
+
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout.String(), "server: irc.foo.net:6697")
+	assert.Contains(t, stdout.String(), "listen: 0.0.0.0:8080")
+	assert.Empty(t, stderr.String())
+}
+
+func TestConfigCheckInvalid(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"config", "check", "-server", "nope"}, stdout, stderr)
+
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stdout.String(), "server: nope")
+	assert.Contains(t, stderr.String(), "invalid networks[0].server:")
+}
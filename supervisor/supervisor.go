@@ -0,0 +1,273 @@
+package supervisor
+
+import (
+	"animuxd/irc"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const minBackoffMsec = 1000
+const maxBackoffMsec = 300000
+const registerTimeoutMsec = 10000
+const attemptsLimit = 20
+const quitTimeoutMsec = 2000
+
+// Dialer is a function that connects to IRC server and returns IO.
+type Dialer func(ctx context.Context) (io.ReadWriteCloser, error)
+
+// Attempt describes a single connection attempt.
+type Attempt struct {
+	Time    time.Time
+	Success bool
+	Error   string `json:",omitempty"`
+}
+
+// Status describes current state of the supervised connection.
+type Status struct {
+	Connected bool
+	Nick      string
+	// Auth is a result of authentication of the current engine, e.g. "sasl".
+	Auth string
+	// Capabilities are IRCv3 capabilities enabled on the current connection.
+	Capabilities []string
+	// Channels are channels the current engine is on.
+	Channels []string
+	// SendQueue is number of lines waiting for the flood limit.
+	SendQueue int
+	// LagMsec is round-trip time of the last keepalive PING, zero until measured.
+	LagMsec    int64
+	Reconnects int
+	Attempts   []Attempt
+}
+
+// A Supervisor keeps an irc.Engine connected. When the engine's context
+// gets canceled it dials again with exponential backoff and registers a new engine.
+type Supervisor struct {
+	Dial                Dialer
+	NewEngine           func() *irc.Engine
+	OnReconnect         func(engine *irc.Engine)
+	RegisterTimeoutMsec int64
+	MinBackoffMsec      int64
+	MaxBackoffMsec      int64
+	engine              *irc.Engine
+	connected           bool
+	quitting            bool
+	reconnects          int
+	attempts            []Attempt
+	mutex               *sync.RWMutex
+	ctx                 context.Context
+	cancelFunc          context.CancelFunc
+}
+
+// Start connects for the first time and starts watching the connection.
+// Returns an error when the first attempt fails.
+func (s *Supervisor) Start() error {
+	s.mutex = &sync.RWMutex{}
+	s.attempts = make([]Attempt, 0, attemptsLimit)
+	s.ctx, s.cancelFunc = context.WithCancel(context.Background())
+
+	err := s.connect()
+	if err != nil {
+		s.cancelFunc()
+		return err
+	}
+
+	go s.watch()
+
+	return nil
+}
+
+// Stop terminates the supervisor together with the current engine.
+func (s *Supervisor) Stop() {
+	s.cancelFunc()
+}
+
+// Quit stops reconnecting and sends QUIT with given message. Stops the supervisor
+// once server closes the connection or the quit timeout passes.
+func (s *Supervisor) Quit(message string) {
+	s.mutex.Lock()
+	s.quitting = true
+	engine := s.engine
+	s.mutex.Unlock()
+
+	engine.Quit(message)
+
+	select {
+	case <-engine.Context().Done():
+	case <-time.After(quitTimeoutMsec * time.Millisecond):
+	}
+
+	s.Stop()
+}
+
+// Context returns a context.Context which gets canceled when the supervisor stops.
+func (s *Supervisor) Context() context.Context {
+	return s.ctx
+}
+
+// Engine returns currently supervised engine.
+func (s *Supervisor) Engine() *irc.Engine {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.engine
+}
+
+// Status returns a snapshot of the connection state.
+func (s *Supervisor) Status() Status {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	attempts := make([]Attempt, len(s.attempts))
+	copy(attempts, s.attempts)
+
+	return Status{
+		Connected:    s.connected,
+		Nick:         s.engine.Nick(),
+		Auth:         s.engine.AuthStatus().String(),
+		Capabilities: s.engine.EnabledCapabilities(),
+		Channels:     s.engine.Channels(),
+		SendQueue:    s.engine.QueueLength(),
+		LagMsec:      s.engine.Lag().Milliseconds(),
+		Reconnects:   s.reconnects,
+		Attempts:     attempts,
+	}
+}
+
+// StatusJSON writes JSON representation of Status to given writer.
+func (s *Supervisor) StatusJSON(writer io.Writer) error {
+	return json.NewEncoder(writer).Encode(s.Status())
+}
+
+func (s *Supervisor) watch() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.Engine().Context().Done():
+		}
+
+		s.mutex.Lock()
+		s.connected = false
+		quitting := s.quitting
+		s.mutex.Unlock()
+
+		if quitting {
+			return
+		}
+
+		for attempt := 0; ; attempt++ {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(s.backoff(attempt)):
+			}
+
+			if s.connect() == nil {
+				break
+			}
+		}
+
+		s.mutex.Lock()
+		s.reconnects++
+		engine := s.engine
+		s.mutex.Unlock()
+
+		if s.OnReconnect != nil {
+			s.OnReconnect(engine)
+		}
+	}
+}
+
+// connect dials, starts and registers a new engine.
+func (s *Supervisor) connect() error {
+	engine, err := s.startEngine()
+	s.record(err)
+
+	if err == nil {
+		s.mutex.Lock()
+		s.engine = engine
+		s.connected = true
+		s.mutex.Unlock()
+	}
+
+	return err
+}
+
+func (s *Supervisor) startEngine() (*irc.Engine, error) {
+	stream, err := s.Dial(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &irc.Engine{}
+	if s.NewEngine != nil {
+		engine = s.NewEngine()
+	}
+	engine.Start(stream)
+
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			engine.Stop()
+		case <-engine.Context().Done():
+		}
+	}()
+
+	registerTimeout := s.RegisterTimeoutMsec
+	if registerTimeout <= 0 {
+		registerTimeout = registerTimeoutMsec
+	}
+
+	registerPromise := engine.Register(engine.Context(), registerTimeout)
+	if !<-registerPromise {
+		engine.Stop()
+		return nil, errors.New("Could not register on IRC server")
+	}
+
+	return engine, nil
+}
+
+func (s *Supervisor) record(err error) {
+	attempt := Attempt{Time: time.Now(), Success: err == nil}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	s.mutex.Lock()
+	if len(s.attempts) == attemptsLimit {
+		s.attempts = s.attempts[1:]
+	}
+	s.attempts = append(s.attempts, attempt)
+	s.mutex.Unlock()
+}
+
+// backoff returns exponentially growing delay with a random jitter
+// that keeps it between half and whole of the computed value.
+func (s *Supervisor) backoff(attempt int) time.Duration {
+	minBackoff := s.MinBackoffMsec
+	if minBackoff <= 0 {
+		minBackoff = minBackoffMsec
+	}
+	maxBackoff := s.MaxBackoffMsec
+	if maxBackoff <= 0 {
+		maxBackoff = maxBackoffMsec
+	}
+
+	delay := minBackoff
+	for i := 0; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	jittered := delay/2 + rand.Int63n(delay/2+1)
+
+	return time.Duration(jittered) * time.Millisecond
+}
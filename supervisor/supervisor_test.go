@@ -0,0 +1,187 @@
+package supervisor
+
+import (
+	"animuxd/irc"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var nickPattern = regexp.MustCompile("^NICK (\\S*)$")
+var quitPattern = regexp.MustCompile("^QUIT :(.*)$")
+
+// fakeServer hands out in-memory connections that welcome every nick.
+type fakeServer struct {
+	conns    []net.Conn
+	failures int
+	quits    int
+	mutex    sync.Mutex
+}
+
+func (s *fakeServer) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("connection refused")
+	}
+
+	client, server := net.Pipe()
+	s.conns = append(s.conns, server)
+
+	go func() {
+		scanner := bufio.NewScanner(server)
+		for scanner.Scan() {
+			if captures := nickPattern.FindStringSubmatch(scanner.Text()); captures != nil {
+				fmt.Fprintf(server, ":fake.irc 001 %s :Welcome\r\n", captures[1])
+			}
+			if quitPattern.MatchString(scanner.Text()) {
+				s.mutex.Lock()
+				s.quits++
+				s.mutex.Unlock()
+				server.Close()
+			}
+		}
+	}()
+
+	return client, nil
+}
+
+func (s *fakeServer) Drop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conns[len(s.conns)-1].Close()
+}
+
+func TestStart(t *testing.T) {
+	server := &fakeServer{}
+	supervisor := &Supervisor{Dial: server.Dial}
+
+	err := supervisor.Start()
+	defer supervisor.Stop()
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, supervisor.Engine().Nick())
+
+	status := supervisor.Status()
+	assert.True(t, status.Connected)
+	assert.Equal(t, "none", status.Auth)
+	assert.Empty(t, status.Capabilities)
+	assert.Empty(t, status.Channels)
+	assert.Equal(t, 0, status.SendQueue)
+	assert.Equal(t, int64(0), status.LagMsec)
+	assert.Equal(t, 0, status.Reconnects)
+	assert.Len(t, status.Attempts, 1)
+	assert.True(t, status.Attempts[0].Success)
+}
+
+func TestStartFails(t *testing.T) {
+	server := &fakeServer{failures: 1}
+	supervisor := &Supervisor{Dial: server.Dial}
+
+	err := supervisor.Start()
+
+	assert.NotNil(t, err)
+	<-supervisor.Context().Done()
+}
+
+func TestReconnects(t *testing.T) {
+	server := &fakeServer{}
+	reconnected := make(chan *irc.Engine, 1)
+	supervisor := &Supervisor{
+		Dial:           server.Dial,
+		MinBackoffMsec: 10,
+		MaxBackoffMsec: 20,
+		OnReconnect: func(engine *irc.Engine) {
+			reconnected <- engine
+		},
+	}
+
+	supervisor.Start()
+	defer supervisor.Stop()
+	firstEngine := supervisor.Engine()
+
+	server.mutex.Lock()
+	server.failures = 2
+	server.mutex.Unlock()
+	server.Drop()
+
+	select {
+	case engine := <-reconnected:
+		assert.NotEqual(t, firstEngine, engine)
+		assert.Equal(t, engine, supervisor.Engine())
+	case <-time.After(time.Second):
+		t.Fatal("Did not reconnect")
+	}
+
+	status := supervisor.Status()
+	assert.True(t, status.Connected)
+	assert.Equal(t, 1, status.Reconnects)
+	assert.Len(t, status.Attempts, 4)
+	assert.False(t, status.Attempts[1].Success)
+	assert.Equal(t, "connection refused", status.Attempts[2].Error)
+	assert.True(t, status.Attempts[3].Success)
+}
+
+func TestStopStopsEngine(t *testing.T) {
+	server := &fakeServer{}
+	supervisor := &Supervisor{Dial: server.Dial}
+
+	supervisor.Start()
+	ctx := supervisor.Engine().Context()
+	supervisor.Stop()
+
+	<-ctx.Done()
+}
+
+func TestQuit(t *testing.T) {
+	server := &fakeServer{}
+	supervisor := &Supervisor{Dial: server.Dial, MinBackoffMsec: 1, MaxBackoffMsec: 1}
+
+	supervisor.Start()
+	supervisor.Quit("bye")
+
+	<-supervisor.Context().Done()
+	time.Sleep(20 * time.Millisecond)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, 1, server.quits)
+	assert.Len(t, server.conns, 1)
+}
+
+func TestBackoff(t *testing.T) {
+	supervisor := &Supervisor{MinBackoffMsec: 100, MaxBackoffMsec: 1000}
+
+	for attempt, max := range []int64{100, 200, 400, 800, 1000, 1000} {
+		delay := supervisor.backoff(attempt)
+		assert.GreaterOrEqual(t, int64(delay), max/2*int64(time.Millisecond))
+		assert.LessOrEqual(t, int64(delay), max*int64(time.Millisecond))
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	server := &fakeServer{}
+	supervisor := &Supervisor{Dial: server.Dial}
+	supervisor.Start()
+	defer supervisor.Stop()
+
+	buff := new(bytes.Buffer)
+	err := supervisor.StatusJSON(buff)
+
+	assert.Nil(t, err)
+	assert.Regexp(t, regexp.MustCompile(`"Connected":true`), buff.String())
+	assert.Regexp(t, regexp.MustCompile(`"Reconnects":0`), buff.String())
+}
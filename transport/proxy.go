@@ -0,0 +1,198 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	ProxySOCKS5 = "socks5"
+	ProxyHTTP   = "http"
+)
+
+const maxProxyResponseHeadLength = 8192
+
+// Proxy describes a proxy connections are routed through.
+type Proxy struct {
+	// Type is either socks5 or http. Empty disables the proxy.
+	Type    string
+	Address string
+	// Username and Password are optional credentials for the proxy.
+	Username string
+	Password string
+}
+
+// Dial connects to given address through the proxy or directly if it is disabled.
+func (p Proxy) Dial(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	if p.Type == "" {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", p.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withDeadline(ctx, conn, timeout, func() error {
+		switch p.Type {
+		case ProxySOCKS5:
+			return p.connectSOCKS5(conn, address)
+		case ProxyHTTP:
+			return p.connectHTTP(conn, address)
+		default:
+			return fmt.Errorf("Unknown proxy type %s", p.Type)
+		}
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// connectSOCKS5 asks SOCKS5 proxy to connect to given address, as described in RFC 1928 and RFC 1929.
+func (p Proxy) connectSOCKS5(conn net.Conn, address string) error {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{0x00}
+	if p.Username != "" {
+		methods = []byte{0x00, 0x02}
+	}
+	_, err = conn.Write(append([]byte{0x05, byte(len(methods))}, methods...))
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("Proxy does not speak SOCKS5")
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if len(p.Username) > 255 || len(p.Password) > 255 {
+			return errors.New("Proxy credentials are too long")
+		}
+		auth := []byte{0x01, byte(len(p.Username))}
+		auth = append(auth, p.Username...)
+		auth = append(auth, byte(len(p.Password)))
+		auth = append(auth, p.Password...)
+		if _, err = conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("Proxy rejected credentials")
+		}
+	default:
+		return errors.New("Proxy requires unsupported authentication")
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("Host name is too long")
+		}
+		request = append(request, 0x03, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, 0x01)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, 0x04)
+		request = append(request, ip.To16()...)
+	}
+	request = append(request, 0, 0)
+	binary.BigEndian.PutUint16(request[len(request)-2:], uint16(port))
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		return fmt.Errorf("Proxy failed to connect, reply code %d", head[1])
+	}
+
+	var boundLength int
+	switch head[3] {
+	case 0x01:
+		boundLength = net.IPv4len
+	case 0x04:
+		boundLength = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		boundLength = int(length[0])
+	default:
+		return errors.New("Proxy replied with unknown address type")
+	}
+	_, err = io.ReadFull(conn, make([]byte, boundLength+2))
+
+	return err
+}
+
+// connectHTTP asks HTTP proxy to tunnel the connection to given address with CONNECT method.
+// Response is read byte by byte so no data following it gets lost.
+func (p Proxy) connectHTTP(conn net.Conn, address string) error {
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if p.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+		request += fmt.Sprintf("Proxy-Authorization: Basic %s\r\n", credentials)
+	}
+	if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+		return err
+	}
+
+	head := []byte{}
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+		if len(head) > maxProxyResponseHeadLength {
+			return errors.New("Proxy response is too long")
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		head = append(head, b[0])
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), nil)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Proxy failed to connect: %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTarget starts a server that writes "foo" to every connection.
+func startTarget(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("foo"))
+			conn.Close()
+		}
+	}()
+
+	return listener
+}
+
+// relay copies data both ways between connections until one of them closes.
+func relay(client net.Conn, target net.Conn) {
+	go func() {
+		io.Copy(target, client)
+		target.Close()
+	}()
+	io.Copy(client, target)
+	client.Close()
+}
+
+// startSOCKS5Proxy starts SOCKS5 proxy stand-in. It requires credentials when username is given.
+// Requested addresses are sent on the returned channel.
+func startSOCKS5Proxy(t *testing.T, username string, password string) (net.Listener, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	requested := make(chan string, 1)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				greeting := make([]byte, 2)
+				io.ReadFull(conn, greeting)
+				io.ReadFull(conn, make([]byte, greeting[1]))
+
+				if username == "" {
+					conn.Write([]byte{0x05, 0x00})
+				} else {
+					conn.Write([]byte{0x05, 0x02})
+
+					length := make([]byte, 2)
+					io.ReadFull(conn, length)
+					user := make([]byte, length[1])
+					io.ReadFull(conn, user)
+					io.ReadFull(conn, length[:1])
+					pass := make([]byte, length[0])
+					io.ReadFull(conn, pass)
+
+					if string(user) != username || string(pass) != password {
+						conn.Write([]byte{0x01, 0x01})
+						return
+					}
+					conn.Write([]byte{0x01, 0x00})
+				}
+
+				head := make([]byte, 4)
+				io.ReadFull(conn, head)
+				var host string
+				switch head[3] {
+				case 0x01:
+					ip := make([]byte, net.IPv4len)
+					io.ReadFull(conn, ip)
+					host = net.IP(ip).String()
+				case 0x03:
+					length := make([]byte, 1)
+					io.ReadFull(conn, length)
+					name := make([]byte, length[0])
+					io.ReadFull(conn, name)
+					host = string(name)
+				}
+				port := make([]byte, 2)
+				io.ReadFull(conn, port)
+				address := net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port)))
+				requested <- address
+
+				target, err := net.Dial("tcp", address)
+				if err != nil {
+					conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+
+				relay(conn, target)
+			}(conn)
+		}
+	}()
+
+	return listener, requested
+}
+
+// startHTTPProxy starts HTTP CONNECT proxy stand-in. It requires credentials when username is given.
+func startHTTPProxy(t *testing.T, username string, password string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	expectedAuthorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				request, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || request.Method != http.MethodConnect {
+					return
+				}
+				if username != "" && request.Header.Get("Proxy-Authorization") != expectedAuthorization {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+
+				target, err := net.Dial("tcp", request.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+				relay(conn, target)
+			}(conn)
+		}
+	}()
+
+	return listener
+}
+
+func readAll(t *testing.T, conn net.Conn) string {
+	defer conn.Close()
+
+	data, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+
+	return string(data)
+}
+
+func TestDialSOCKS5(t *testing.T) {
+	target := startTarget(t)
+	defer target.Close()
+	proxy, requested := startSOCKS5Proxy(t, "", "")
+	defer proxy.Close()
+
+	conn, err := Dial(context.Background(), target.Addr().String(), Options{
+		Timeout: time.Second,
+		Proxy:   Proxy{Type: ProxySOCKS5, Address: proxy.Addr().String()},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, target.Addr().String(), <-requested)
+	assert.Equal(t, "foo", readAll(t, conn))
+}
+
+func TestDialSOCKS5WithCredentials(t *testing.T) {
+	target := startTarget(t)
+	defer target.Close()
+	proxy, _ := startSOCKS5Proxy(t, "ownadi", "secret")
+	defer proxy.Close()
+
+	conn, err := Proxy{Type: ProxySOCKS5, Address: proxy.Addr().String(), Username: "ownadi", Password: "secret"}.
+		Dial(context.Background(), target.Addr().String(), time.Second)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", readAll(t, conn))
+
+	_, err = Proxy{Type: ProxySOCKS5, Address: proxy.Addr().String(), Username: "ownadi", Password: "wrong"}.
+		Dial(context.Background(), target.Addr().String(), time.Second)
+
+	assert.NotNil(t, err)
+}
+
+func TestDialSOCKS5HostName(t *testing.T) {
+	target := startTarget(t)
+	defer target.Close()
+	proxy, requested := startSOCKS5Proxy(t, "", "")
+	defer proxy.Close()
+
+	port := strconv.Itoa(target.Addr().(*net.TCPAddr).Port)
+	conn, err := Proxy{Type: ProxySOCKS5, Address: proxy.Addr().String()}.
+		Dial(context.Background(), net.JoinHostPort("localhost", port), time.Second)
+
+	assert.Equal(t, net.JoinHostPort("localhost", port), <-requested)
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestDialHTTPProxy(t *testing.T) {
+	target := startTarget(t)
+	defer target.Close()
+	proxy := startHTTPProxy(t, "ownadi", "secret")
+	defer proxy.Close()
+
+	conn, err := Proxy{Type: ProxyHTTP, Address: proxy.Addr().String(), Username: "ownadi", Password: "secret"}.
+		Dial(context.Background(), target.Addr().String(), time.Second)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", readAll(t, conn))
+
+	_, err = Proxy{Type: ProxyHTTP, Address: proxy.Addr().String()}.
+		Dial(context.Background(), target.Addr().String(), time.Second)
+
+	assert.NotNil(t, err)
+}
+
+func TestDialTLSThroughProxy(t *testing.T) {
+	serverCertificate := generateCertificate(t, "server")
+	listener, _ := startTLSIrcServer(t, serverCertificate.certificate)
+	defer listener.Close()
+	proxy := startHTTPProxy(t, "", "")
+	defer proxy.Close()
+
+	conn, err := Dial(context.Background(), listener.Addr().String(), Options{
+		TLS:   TLS{Enabled: true, PinSHA256: fingerprint(serverCertificate.certificate)},
+		Proxy: Proxy{Type: ProxyHTTP, Address: proxy.Addr().String()},
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, registers(conn))
+}
+
+func TestDialUnknownProxyType(t *testing.T) {
+	proxy, _ := startSOCKS5Proxy(t, "", "")
+	defer proxy.Close()
+
+	_, err := Proxy{Type: "gopher", Address: proxy.Addr().String()}.
+		Dial(context.Background(), "127.0.0.1:1", time.Second)
+
+	assert.NotNil(t, err)
+}
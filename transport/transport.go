@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// TLS describes how a connection should be secured.
+type TLS struct {
+	Enabled bool
+	// InsecureSkipVerify accepts any certificate, e.g. of self-signed test servers.
+	InsecureSkipVerify bool
+	// PinSHA256 is a hex encoded SHA-256 fingerprint of the expected server certificate.
+	// When set, the certificate is checked against it instead of the system roots.
+	PinSHA256 string
+	// ClientCert and ClientKey are paths to PEM files presented to the server, e.g. for CertFP.
+	ClientCert string
+	ClientKey  string
+}
+
+// Options describes how to connect to a remote address.
+type Options struct {
+	Timeout time.Duration
+	TLS     TLS
+	Proxy   Proxy
+}
+
+// Dial connects to given address, through the proxy if one is set,
+// and, if enabled, performs TLS handshake.
+func Dial(ctx context.Context, address string, options Options) (net.Conn, error) {
+	var tlsConfig *tls.Config
+	if options.TLS.Enabled {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig, err = options.TLS.Config(host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := options.Proxy.Dial(ctx, address, options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		return conn, nil
+	}
+
+	return handshake(ctx, conn, tlsConfig, options.Timeout)
+}
+
+func handshake(ctx context.Context, conn net.Conn, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	tlsConn := tls.Client(conn, tlsConfig)
+
+	err := withDeadline(ctx, conn, timeout, tlsConn.Handshake)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// withDeadline runs exchange over the connection bounded by both the timeout and the context.
+func withDeadline(ctx context.Context, conn net.Conn, timeout time.Duration, exchange func() error) error {
+	deadline, hasDeadline := ctx.Deadline()
+	if timeout > 0 && (!hasDeadline || time.Now().Add(timeout).Before(deadline)) {
+		deadline, hasDeadline = time.Now().Add(timeout), true
+	}
+	if hasDeadline {
+		conn.SetDeadline(deadline)
+	}
+
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	err := exchange()
+	conn.SetDeadline(time.Time{})
+
+	return err
+}
+
+// Config builds tls.Config for connecting to the server under given name.
+func (t TLS) Config(serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.PinSHA256 != "" {
+		pin, err := ParseFingerprint(t.PinSHA256)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("Server did not present a certificate")
+			}
+
+			fingerprint := sha256.Sum256(rawCerts[0])
+			if string(fingerprint[:]) != string(pin) {
+				return errors.New("Server certificate does not match the pin")
+			}
+
+			return nil
+		}
+	}
+
+	if t.ClientCert != "" || t.ClientKey != "" {
+		certificate, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// ParseFingerprint decodes hex encoded SHA-256 fingerprint. Colons are allowed between bytes.
+func ParseFingerprint(fingerprint string) ([]byte, error) {
+	decoded, err := hex.DecodeString(strings.Replace(fingerprint, ":", "", -1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) != sha256.Size {
+		return nil, errors.New("Fingerprint must be 32 bytes long")
+	}
+
+	return decoded, nil
+}
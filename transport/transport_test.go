@@ -0,0 +1,232 @@
+package transport
+
+import (
+	"animuxd/irc"
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var nickPattern = regexp.MustCompile("^NICK (\\S*)$")
+
+type testCertificate struct {
+	certificate tls.Certificate
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+func generateCertificate(t *testing.T, commonName string) testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.Nil(t, err)
+
+	return testCertificate{certificate: certificate, certPEM: certPEM, keyPEM: keyPEM}
+}
+
+func fingerprint(certificate tls.Certificate) string {
+	sum := sha256.Sum256(certificate.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+// startTLSIrcServer starts TLS IRC stand-in that welcomes every nick.
+// Common names of client certificates are sent on the returned channel.
+func startTLSIrcServer(t *testing.T, serverCertificate tls.Certificate) (net.Listener, chan string) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientAuth:   tls.RequestClientCert,
+	})
+	assert.Nil(t, err)
+
+	clientNames := make(chan string, 1)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn *tls.Conn) {
+				defer conn.Close()
+
+				if conn.Handshake() != nil {
+					return
+				}
+				peerCertificates := conn.ConnectionState().PeerCertificates
+				if len(peerCertificates) > 0 {
+					clientNames <- peerCertificates[0].Subject.CommonName
+				}
+
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					if captures := nickPattern.FindStringSubmatch(scanner.Text()); captures != nil {
+						fmt.Fprintf(conn, ":fake.irc 001 %s :Welcome\r\n", captures[1])
+					}
+				}
+			}(conn.(*tls.Conn))
+		}
+	}()
+
+	return listener, clientNames
+}
+
+func registers(conn net.Conn) bool {
+	engine := &irc.Engine{}
+	engine.Start(conn)
+	defer engine.Stop()
+
+	ctx, cancel := context.WithTimeout(engine.Context(), time.Second)
+	defer cancel()
+
+	return <-engine.Register(ctx, 500)
+}
+
+func TestDialPlain(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Write([]byte("foo"))
+			conn.Close()
+		}
+	}()
+
+	conn, err := Dial(context.Background(), listener.Addr().String(), Options{Timeout: time.Second})
+	assert.Nil(t, err)
+
+	data, _ := ioutil.ReadAll(conn)
+	assert.Equal(t, "foo", string(data))
+}
+
+func TestDialTLSUntrusted(t *testing.T) {
+	serverCertificate := generateCertificate(t, "server")
+	listener, _ := startTLSIrcServer(t, serverCertificate.certificate)
+	defer listener.Close()
+
+	_, err := Dial(context.Background(), listener.Addr().String(), Options{TLS: TLS{Enabled: true}})
+
+	assert.NotNil(t, err)
+}
+
+func TestDialTLSInsecureSkipVerify(t *testing.T) {
+	serverCertificate := generateCertificate(t, "server")
+	listener, _ := startTLSIrcServer(t, serverCertificate.certificate)
+	defer listener.Close()
+
+	conn, err := Dial(context.Background(), listener.Addr().String(), Options{
+		TLS: TLS{Enabled: true, InsecureSkipVerify: true},
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, registers(conn))
+}
+
+func TestDialTLSPinned(t *testing.T) {
+	serverCertificate := generateCertificate(t, "server")
+	listener, _ := startTLSIrcServer(t, serverCertificate.certificate)
+	defer listener.Close()
+
+	conn, err := Dial(context.Background(), listener.Addr().String(), Options{
+		TLS: TLS{Enabled: true, PinSHA256: fingerprint(serverCertificate.certificate)},
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, registers(conn))
+}
+
+func TestDialTLSPinMismatch(t *testing.T) {
+	serverCertificate := generateCertificate(t, "server")
+	otherCertificate := generateCertificate(t, "other")
+	listener, _ := startTLSIrcServer(t, serverCertificate.certificate)
+	defer listener.Close()
+
+	_, err := Dial(context.Background(), listener.Addr().String(), Options{
+		TLS: TLS{Enabled: true, PinSHA256: fingerprint(otherCertificate.certificate)},
+	})
+
+	assert.NotNil(t, err)
+}
+
+func TestDialTLSClientCertificate(t *testing.T) {
+	serverCertificate := generateCertificate(t, "server")
+	clientCertificate := generateCertificate(t, "ownadi")
+	listener, clientNames := startTLSIrcServer(t, serverCertificate.certificate)
+	defer listener.Close()
+
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	ioutil.WriteFile(certPath, clientCertificate.certPEM, 0600)
+	ioutil.WriteFile(keyPath, clientCertificate.keyPEM, 0600)
+
+	conn, err := Dial(context.Background(), listener.Addr().String(), Options{
+		TLS: TLS{Enabled: true, InsecureSkipVerify: true, ClientCert: certPath, ClientKey: keyPath},
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, registers(conn))
+	assert.Equal(t, "ownadi", <-clientNames)
+}
+
+func TestDialTLSMissingClientCertificate(t *testing.T) {
+	_, err := Dial(context.Background(), "127.0.0.1:1", Options{
+		TLS: TLS{Enabled: true, ClientCert: "/nonexistent.crt", ClientKey: "/nonexistent.key"},
+	})
+
+	assert.NotNil(t, err)
+}
+
+func TestParseFingerprint(t *testing.T) {
+	hexFingerprint := "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+	withColons := "00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF"
+
+	plain, err := ParseFingerprint(hexFingerprint)
+	assert.Nil(t, err)
+	colons, err := ParseFingerprint(withColons)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, colons)
+
+	_, err = ParseFingerprint("0011")
+	assert.NotNil(t, err)
+	_, err = ParseFingerprint("foo")
+	assert.NotNil(t, err)
+}
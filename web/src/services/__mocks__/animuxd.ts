@@ -15,6 +15,7 @@ export const getDownloads = jest.fn(
         Status: DownloadStatus.Downloading,
         AvgSpeed: 1024 * 1024 * 3,
         CurrentSpeed: 1024 * 1024 * 10,
+        Network: "rizon",
       },
       {
         FileName: "bar.mkv",
@@ -23,6 +24,7 @@ export const getDownloads = jest.fn(
         Status: DownloadStatus.Waiting,
         AvgSpeed: 0,
         CurrentSpeed: 0,
+        Network: "rizon",
       },
     ]);
   }
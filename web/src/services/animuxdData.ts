@@ -1,10 +1,16 @@
-export const ANIMUXD_API_URL = "http://localhost:1337";
+// Builds served by the daemon talk to the API on the same origin.
+export const ANIMUXD_API_URL =
+  process.env.REACT_APP_ANIMUXD_API_URL ??
+  (process.env.NODE_ENV === "development" ? "http://localhost:1337" : "");
 
 export enum DownloadStatus {
   Waiting = 0,
   Downloading = 1,
   Done = 2,
   Failed = 3,
+  Interrupted = 4,
+  Deferred = 5,
+  WaitingForBot = 6,
 }
 
 export const DownloadStatusString = {
@@ -12,6 +18,9 @@ export const DownloadStatusString = {
   [DownloadStatus.Downloading]: "Downloading",
   [DownloadStatus.Done]: "Done",
   [DownloadStatus.Failed]: "Failed",
+  [DownloadStatus.Interrupted]: "Interrupted",
+  [DownloadStatus.Deferred]: "Deferred",
+  [DownloadStatus.WaitingForBot]: "Waiting for bot",
 };
 
 export type Download = {
@@ -21,4 +30,7 @@ export type Download = {
   AvgSpeed: number;
   Downloaded: number;
   Size: number;
+  Network: string;
+  BotMessage?: string;
+  Error?: string;
 };
@@ -0,0 +1,64 @@
+// Package webui bundles the built web app into the binary.
+// Run `go generate ./webui` after building the app under web/ to refresh Assets.
+package webui
+
+//go:generate go run ./gen ../web/build assets.go
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// A Bundle is an in-memory http.FileSystem. Keys are slash separated paths
+// starting with a slash, e.g. /index.html.
+type Bundle map[string][]byte
+
+// Open returns file under given path. Directories are not listed.
+func (b Bundle) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+
+	data, ok := b[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return &bundleFile{Reader: bytes.NewReader(data), info: bundleFileInfo{name: path.Base(name), size: int64(len(data))}}, nil
+}
+
+// Empty tells whether the binary was built without the web app.
+func (b Bundle) Empty() bool {
+	return len(b) == 0
+}
+
+type bundleFile struct {
+	*bytes.Reader
+	info bundleFileInfo
+}
+
+func (f *bundleFile) Close() error {
+	return nil
+}
+
+func (f *bundleFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("Bundle files are not directories")
+}
+
+func (f *bundleFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+type bundleFileInfo struct {
+	name string
+	size int64
+}
+
+func (i bundleFileInfo) Name() string       { return i.name }
+func (i bundleFileInfo) Size() int64        { return i.size }
+func (i bundleFileInfo) Mode() os.FileMode  { return 0444 }
+func (i bundleFileInfo) ModTime() time.Time { return time.Time{} }
+func (i bundleFileInfo) IsDir() bool        { return false }
+func (i bundleFileInfo) Sys() interface{}   { return nil }
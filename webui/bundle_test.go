@@ -0,0 +1,33 @@
+package webui
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundleOpen(t *testing.T) {
+	bundle := Bundle{"/static/main.js": []byte("foo")}
+
+	file, err := bundle.Open("static/../static/main.js")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	data, _ := ioutil.ReadAll(file)
+	assert.Equal(t, "foo", string(data))
+
+	info, err := file.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, "main.js", info.Name())
+	assert.Equal(t, int64(3), info.Size())
+	assert.False(t, info.IsDir())
+}
+
+func TestBundleOpenMissing(t *testing.T) {
+	_, err := Bundle{}.Open("/index.html")
+
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, Bundle{}.Empty())
+}
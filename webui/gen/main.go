@@ -0,0 +1,76 @@
+// Command gen writes Go source of webui.Assets built from given directory.
+//
+//	go run ./gen <build dir> <output file>
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: gen <build dir> <output file>")
+		os.Exit(2)
+	}
+
+	err := generate(os.Args[1], os.Args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func generate(dir string, output string) error {
+	files := map[string][]byte{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		files["/"+filepath.ToSlash(relative)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	source := &bytes.Buffer{}
+	fmt.Fprintln(source, "// Code generated by webui/gen. DO NOT EDIT.")
+	fmt.Fprintln(source)
+	fmt.Fprintln(source, "package webui")
+	fmt.Fprintln(source)
+	fmt.Fprintln(source, "// Assets is the built web app. It is empty unless generated from a build.")
+	fmt.Fprintln(source, "var Assets = Bundle{")
+	for _, name := range names {
+		fmt.Fprintf(source, "%q: []byte(%q),\n", name, files[name])
+	}
+	fmt.Fprintln(source, "}")
+
+	formatted, err := format.Source(source.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(output, formatted, 0644)
+}
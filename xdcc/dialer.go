@@ -0,0 +1,55 @@
+package xdcc
+
+import (
+	"animuxd/irc"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// DialTCP is a Dialer that connects directly to the address offered by the bot.
+func DialTCP(engine *Engine, payload irc.PrivMsgDccSendPayload) (io.ReadCloser, error) {
+	return net.DialTimeout("tcp", payload.Address(), engine.timeout())
+}
+
+// DialThrough returns a Dialer that connects to the address offered by the bot
+// with given function, e.g. through a proxy.
+func DialThrough(dial func(ctx context.Context, address string) (net.Conn, error)) Dialer {
+	return func(engine *Engine, payload irc.PrivMsgDccSendPayload) (io.ReadCloser, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), engine.timeout())
+		defer cancel()
+
+		return dial(ctx, payload.Address())
+	}
+}
+
+// DialTLS returns a Dialer that secures connections of the given one with TLS when the offer is DCC SSEND.
+// Bots use self-signed certificates, so they are not verified; TLS only protects against eavesdropping.
+func DialTLS(dialer Dialer) Dialer {
+	return func(engine *Engine, payload irc.PrivMsgDccSendPayload) (io.ReadCloser, error) {
+		rc, err := dialer(engine, payload)
+		if err != nil || !payload.Secure {
+			return rc, err
+		}
+
+		conn, isConn := rc.(net.Conn)
+		if !isConn {
+			rc.Close()
+			return nil, errors.New("Cannot secure connection which is not net.Conn")
+		}
+
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		conn.SetDeadline(time.Now().Add(engine.timeout()))
+		err = tlsConn.Handshake()
+		conn.SetDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+}
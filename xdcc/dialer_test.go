@@ -0,0 +1,130 @@
+package xdcc
+
+import (
+	"animuxd/irc"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialTCP(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Write([]byte("foo"))
+			conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	payload := irc.PrivMsgDccSendPayload{FileName: "foo.bar", IP: addr.IP, Port: uint64(addr.Port), FileLength: 3}
+
+	conn, err := DialTCP(&Engine{}, payload)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	data, _ := ioutil.ReadAll(conn)
+	assert.Equal(t, "foo", string(data))
+}
+
+func TestDialThrough(t *testing.T) {
+	var dialedAddress string
+	client, server := net.Pipe()
+	dial := func(ctx context.Context, address string) (net.Conn, error) {
+		dialedAddress = address
+		return client, nil
+	}
+	go func() {
+		server.Write([]byte("foo"))
+		server.Close()
+	}()
+
+	payload := irc.PrivMsgDccSendPayload{FileName: "foo.bar", IP: net.ParseIP("10.0.0.1"), Port: 1337, FileLength: 3}
+
+	conn, err := DialThrough(dial)(&Engine{}, payload)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	data, _ := ioutil.ReadAll(conn)
+	assert.Equal(t, "foo", string(data))
+	assert.Equal(t, "10.0.0.1:1337", dialedAddress)
+}
+
+func TestDialTCPAddresses(t *testing.T) {
+	tests := []struct {
+		network string
+		listen  string
+		payload func(port uint64) irc.PrivMsgDccSendPayload
+	}{
+		{"tcp4", "127.0.0.1:0", func(port uint64) irc.PrivMsgDccSendPayload {
+			return irc.PrivMsgDccSendPayload{IP: net.ParseIP("127.0.0.1"), Port: port}
+		}},
+		{"tcp6", "[::1]:0", func(port uint64) irc.PrivMsgDccSendPayload {
+			return irc.PrivMsgDccSendPayload{IP: net.ParseIP("::1"), Port: port}
+		}},
+		{"tcp4", "127.0.0.1:0", func(port uint64) irc.PrivMsgDccSendPayload {
+			return irc.PrivMsgDccSendPayload{Host: "localhost", Port: port}
+		}},
+	}
+
+	for _, test := range tests {
+		listener, err := net.Listen(test.network, test.listen)
+		if err != nil {
+			t.Logf("Skipping %s: %v", test.listen, err)
+			continue
+		}
+
+		go func() {
+			conn, err := listener.Accept()
+			if err == nil {
+				conn.Write([]byte("foo"))
+				conn.Close()
+			}
+		}()
+
+		payload := test.payload(uint64(listener.Addr().(*net.TCPAddr).Port))
+		conn, err := DialTCP(&Engine{}, payload)
+		if assert.Nil(t, err, payload.Address()) {
+			data, _ := ioutil.ReadAll(conn)
+			assert.Equal(t, "foo", string(data), payload.Address())
+			conn.Close()
+		}
+		listener.Close()
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "foo")
+	}))
+	defer server.Close()
+
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	payload := irc.PrivMsgDccSendPayload{FileName: "foo.bar", IP: addr.IP, Port: uint64(addr.Port), Secure: true}
+
+	conn, err := DialTLS(DialTCP)(&Engine{}, payload)
+	if assert.Nil(t, err) {
+		defer conn.Close()
+		conn.(net.Conn).Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		data, _ := ioutil.ReadAll(conn)
+		assert.True(t, strings.HasSuffix(string(data), "foo"), string(data))
+	}
+
+	payload.Secure = false
+	conn, err = DialTLS(DialTCP)(&Engine{}, payload)
+	if assert.Nil(t, err) {
+		defer conn.Close()
+		_, isTLS := conn.(interface{ Handshake() error })
+		assert.False(t, isTLS)
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"animuxd/irc"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const timeoutMsec = 2000
+const ssendFallbackMsec = 30000
 
 type DownloadStatus int
 
@@ -20,6 +23,46 @@ const (
 	Downloading
 	Done
 	Failed
+	Interrupted
+	// Deferred downloads were queued by the bot or hit its transfer limit.
+	// They are still expected to be sent.
+	Deferred
+	// WaitingForBot downloads are requested again once their bot comes back online.
+	WaitingForBot
+)
+
+func (s DownloadStatus) String() string {
+	switch s {
+	case Waiting:
+		return "waiting"
+	case Downloading:
+		return "downloading"
+	case Done:
+		return "done"
+	case Failed:
+		return "failed"
+	case Interrupted:
+		return "interrupted"
+	case Deferred:
+		return "deferred"
+	case WaitingForBot:
+		return "waiting for bot"
+	default:
+		return "unknown"
+	}
+}
+
+// Encryption is a policy of encrypting transfers with DCC SSEND.
+type Encryption string
+
+const (
+	// EncryptionNone requests plain DCC SEND. SSEND offers are still accepted.
+	EncryptionNone Encryption = ""
+	// EncryptionPrefer requests SSEND but accepts plain offers too. Bots which do not answer
+	// SSEND within SSendFallbackMsec get requested with plain XDCC SEND.
+	EncryptionPrefer Encryption = "prefer"
+	// EncryptionRequire requests SSEND and rejects plain offers.
+	EncryptionRequire Encryption = "require"
 )
 
 // Dialer is a function that connects somewhere and returns IO.
@@ -37,8 +80,30 @@ type Download struct {
 	AvgSpeed     uint64
 	Downloaded   uint64
 	Size         int64
+	Network      string
 	BotNick      string
 	PackageNo    int
+	// OfferedAt is when the bot offered the file, as reported by the server when it supports server-time.
+	OfferedAt time.Time
+	// BotAccount is the services account of the bot, when the server supports account-tag.
+	BotAccount string
+	// BotMessage is the last recognized reply of the bot to the request, e.g. why it failed.
+	BotMessage string
+	// Error is the last IRC error met while requesting, e.g. a channel of the bot we could not join.
+	// The download fails only when the bot is offline.
+	Error string
+	// channels were joined for the download. They are left once no active download needs them.
+	channels []string
+	// botGone is set when the bot goes offline during the transfer.
+	botGone bool
+	// refused is set when the bot replied it will not send the package.
+	// Such downloads are not requested again on reconnects.
+	refused bool
+}
+
+// active tells whether the download still needs the bot and its channels.
+func (d *Download) active() bool {
+	return d.Status == Waiting || d.Status == Deferred || d.Status == Downloading || d.Status == WaitingForBot
 }
 
 // DownloadJSON extends Download with some JSON-useful fields.
@@ -47,35 +112,137 @@ type DownloadJSON struct {
 	*Download
 }
 
+// network binds a named IRC connection to the engine.
+type network struct {
+	ircEngine  irc.IRCEngine
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
 // An Engine represents that part of the app which is responsible
-// for handling XDCC download method. Works on top of irc.Engine.
+// for handling XDCC download method. Works on top of irc.Engines
+// of one or more networks.
 type Engine struct {
-	ircEngine      irc.IRCEngine
+	networks       map[string]*network
+	networksMutex  *sync.RWMutex
 	dialer         Dialer
 	openWriter     WriteOpener
 	UnsafeMode     bool
-	Downloads      map[string]*Download
-	downloadsMutex *sync.RWMutex
-	ctx            context.Context
-	cancelFunc     context.CancelFunc
+	TimeoutMsec    int64
+	DefaultNetwork string
+	// Dialers override the dialer passed to Start for given networks, e.g. to use a proxy.
+	Dialers map[string]Dialer
+	// Encryption maps names of networks to their transfer encryption policy.
+	Encryption map[string]Encryption
+	// SSendFallbackMsec is how long EncryptionPrefer waits for an answer to XDCC SSEND, 30000 when zero.
+	SSendFallbackMsec int64
+	// Resumer, when set, lets offers of partially downloaded files continue where they stopped.
+	Resumer      Resumer
+	accepts      map[string]chan int64
+	acceptsMutex *sync.Mutex
+	// Passive is used for offers where the bot waits for us to listen.
+	Passive            Passive
+	passiveTokens      map[string]bool
+	passiveTokensMutex *sync.Mutex
+	Downloads          map[string]*Download
+	downloadsMutex     *sync.RWMutex
+	transfers          *sync.WaitGroup
+	drainCtx           context.Context
+	ctx                context.Context
+	cancelFunc         context.CancelFunc
 }
 
+// ErrUnknownNetwork is sent by RequestFile when the network is not added.
+var ErrUnknownNetwork = errors.New("Unknown network")
+
+// ErrFileNameTaken is sent by RequestFile when an active download on another network
+// uses the file name.
+var ErrFileNameTaken = errors.New("File name is taken by a download on another network")
+
 type XDCCEngine interface {
-	RequestFile(botNick string, packageNo int, fileName string) <-chan bool
+	RequestFile(networkName string, botNick string, packageNo int, fileName string) <-chan error
 	DownloadsJSON(writer io.Writer) error
 }
 
-// Start initializes an engine.
-func (e *Engine) Start(ircEngine irc.IRCEngine, dialer Dialer, writeOpener WriteOpener, unsafe bool) {
-	e.ircEngine = ircEngine
+// Start initializes an engine. Networks have to be added with AddNetwork.
+func (e *Engine) Start(dialer Dialer, writeOpener WriteOpener, unsafe bool) {
+	e.networks = map[string]*network{}
+	e.networksMutex = &sync.RWMutex{}
 	e.dialer = dialer
 	e.openWriter = writeOpener
 	e.UnsafeMode = unsafe
 	e.Downloads = map[string]*Download{}
 	e.downloadsMutex = &sync.RWMutex{}
-	e.ctx, e.cancelFunc = context.WithCancel(ircEngine.Context())
+	e.transfers = &sync.WaitGroup{}
+	e.passiveTokens = map[string]bool{}
+	e.accepts = map[string]chan int64{}
+	e.acceptsMutex = &sync.Mutex{}
+	e.passiveTokensMutex = &sync.Mutex{}
+	e.drainCtx = nil
+	e.ctx, e.cancelFunc = context.WithCancel(context.Background())
+}
 
-	go e.handleIrcPackets()
+// AddNetwork starts handling packets of IRC engine connected to the network under given name.
+// Replaces previous engine of the network, if any.
+func (e *Engine) AddNetwork(networkName string, ircEngine irc.IRCEngine) {
+	n := &network{ircEngine: ircEngine}
+	n.ctx, n.cancelFunc = context.WithCancel(ircEngine.Context())
+
+	go func() {
+		select {
+		case <-e.ctx.Done():
+			n.cancelFunc()
+		case <-n.ctx.Done():
+		}
+	}()
+
+	e.networksMutex.Lock()
+	if previous, exists := e.networks[networkName]; exists {
+		previous.cancelFunc()
+	}
+	e.networks[networkName] = n
+	e.networksMutex.Unlock()
+
+	go e.handleIrcPackets(networkName, n)
+}
+
+// dialerOf returns dialer used for transfers of given network.
+func (e *Engine) dialerOf(networkName string) Dialer {
+	if dialer, ok := e.Dialers[networkName]; ok {
+		return dialer
+	}
+
+	return e.dialer
+}
+
+// resolveNetwork translates empty network name to the default one.
+// When no default is set and there is just one network, that one is used.
+func (e *Engine) resolveNetwork(networkName string) string {
+	if networkName != "" {
+		return networkName
+	}
+	if e.DefaultNetwork != "" {
+		return e.DefaultNetwork
+	}
+
+	e.networksMutex.RLock()
+	defer e.networksMutex.RUnlock()
+
+	if len(e.networks) == 1 {
+		for name := range e.networks {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func (e *Engine) network(networkName string) (*network, bool) {
+	e.networksMutex.RLock()
+	defer e.networksMutex.RUnlock()
+
+	n, exists := e.networks[networkName]
+	return n, exists
 }
 
 func (e *Engine) Stop() {
@@ -86,106 +253,439 @@ func (e *Engine) Context() context.Context {
 	return e.ctx
 }
 
-// Restart starts the Engine on top of a new IRCEngine and resumes uncompleted downloads.
-// TODO: Try to RESUME instead of downloading from zero.
-func (e *Engine) Restart(ircEngine irc.IRCEngine) {
-	e.ircEngine = ircEngine
-	e.ctx, e.cancelFunc = context.WithCancel(ircEngine.Context())
+// Shutdown stops accepting new transfers and gives running ones time
+// until given context gets canceled. Transfers keep going even when IRC connection
+// gets closed in the meantime. Downloads that did not complete are marked as Interrupted.
+// Sends on the returned channel once all transfers are finished and flushed.
+func (e *Engine) Shutdown(ctx context.Context) <-chan bool {
+	r := make(chan bool, 1)
+
+	e.downloadsMutex.Lock()
+	e.drainCtx = ctx
+	e.downloadsMutex.Unlock()
+
+	go func() {
+		defer close(r)
+
+		drained := make(chan bool)
+		go func() {
+			e.transfers.Wait()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+			e.cancelFunc()
+		case <-ctx.Done():
+			e.cancelFunc()
+			<-drained
+		}
+
+		e.downloadsMutex.Lock()
+		for _, download := range e.Downloads {
+			if download.active() {
+				download.Status = Interrupted
+			}
+		}
+		e.downloadsMutex.Unlock()
+
+		r <- true
+	}()
+
+	return r
+}
+
+// LoadDownloads reads downloads in format written by DownloadsJSON.
+func (e *Engine) LoadDownloads(reader io.Reader) error {
+	jsonArray := make([]DownloadJSON, 0)
+	err := json.NewDecoder(reader).Decode(&jsonArray)
+	if err != nil {
+		return err
+	}
 
 	e.downloadsMutex.Lock()
-	requestPromises := make([]<-chan bool, 0, len(e.Downloads))
+	for _, download := range jsonArray {
+		if download.Download != nil {
+			e.Downloads[download.FileName] = download.Download
+		}
+	}
+	e.downloadsMutex.Unlock()
+
+	return nil
+}
+
+// RequestInterrupted requests again all downloads marked as Interrupted.
+// Sends on the returned channel once all requests are sent.
+func (e *Engine) RequestInterrupted() <-chan bool {
+	r := make(chan bool, 1)
+
+	e.downloadsMutex.RLock()
+	requestPromises := make([]<-chan error, 0)
 	for fileName, download := range e.Downloads {
-		if download.Status != Done {
+		if download.Status == Interrupted && download.BotNick != "" {
+			requestPromise := e.RequestFile(download.Network, download.BotNick, download.PackageNo, fileName)
+			requestPromises = append(requestPromises, requestPromise)
+		}
+	}
+	e.downloadsMutex.RUnlock()
+
+	go func() {
+		defer close(r)
+
+		for _, promise := range requestPromises {
+			<-promise
+		}
+
+		r <- true
+	}()
+
+	return r
+}
+
+// Restart replaces IRC engine of given network and requests its uncompleted downloads again,
+// except ones the bot refused to send. With Resumer set, transfers continue from the already
+// stored part of the files.
+func (e *Engine) Restart(networkName string, ircEngine irc.IRCEngine) {
+	e.AddNetwork(networkName, ircEngine)
+
+	e.downloadsMutex.Lock()
+	requestPromises := make([]<-chan error, 0, len(e.Downloads))
+	for fileName, download := range e.Downloads {
+		if download.Status != Done && !download.refused && e.resolveNetwork(download.Network) == networkName {
 			e.Downloads[fileName].Status = Waiting
-			requestPromise := e.RequestFile(download.BotNick, download.PackageNo, fileName)
+			requestPromise := e.RequestFile(networkName, download.BotNick, download.PackageNo, fileName)
 			requestPromises = append(requestPromises, requestPromise)
 		}
 	}
 	e.downloadsMutex.Unlock()
 
-	go e.handleIrcPackets()
-
 	for _, promise := range requestPromises {
 		<-promise
 	}
 }
 
-func (e *Engine) handleIrcPackets() {
-	defer e.cancelFunc()
+func (e *Engine) handleIrcPackets(networkName string, n *network) {
+	defer n.cancelFunc()
 
-	packets := e.ircEngine.IRCPacketsChann()
+	packets := n.ircEngine.IRCPacketsChann()
 
 	for {
 		select {
-		case <-e.ctx.Done():
+		case <-n.ctx.Done():
 			return
 		case packet := <-packets:
 			if packet.Type == irc.PrivMsgDccSend {
 				go func(dccSendPacket irc.Packet) {
-					e.handleDccSendPacket(dccSendPacket)
+					e.handleDccSendPacket(networkName, n, dccSendPacket)
 				}(packet)
 			}
+			if packet.Type == irc.PrivMsgDccAccept {
+				e.handleDccAccept(networkName, packet)
+			}
+			if packet.Type == irc.Notice || packet.Type == irc.PrivMsg {
+				e.handleBotReply(networkName, n, packet)
+			}
+			if packet.Type == irc.Presence {
+				e.handlePresence(networkName, n, packet)
+			}
 		}
 	}
 }
 
+// joinResult lists channels joined for a request and the first irc.IRCError met, if any.
+type joinResult struct {
+	channels []string
+	err      error
+}
+
 // joinBotChannels joins all channels that bot under given nick
-// is present on. Returns promise channel.
-func (e *Engine) joinBotChannels(botNick string) chan bool {
-	r := make(chan bool, 1)
+// is present on. Returns promise channel. Reports the first irc.IRCError met,
+// e.g. irc.ErrNoSuchNick when the bot is offline. Timeouts are not errors.
+func (e *Engine) joinBotChannels(n *network, botNick string) chan joinResult {
+	r := make(chan joinResult, 1)
 
 	go func() {
 		defer close(r)
 
-		channelsContext, cancelChannelsContext := context.WithTimeout(e.ctx, timeoutMsec*time.Millisecond)
-		channelsPromise := e.ircEngine.ChannelsOfUser(channelsContext, botNick)
-		channels := <-channelsPromise
+		channelsContext, cancelChannelsContext := context.WithCancel(n.ctx)
+		channelsPromise := n.ircEngine.ChannelsOfUser(channelsContext, botNick)
+		e.cancelAfterFlush(channelsContext, cancelChannelsContext, n.ircEngine.Flushed())
+		result := <-channelsPromise
+		cancelChannelsContext()
 
-		if channelsContext.Err() != nil {
-			r <- false
-			cancelChannelsContext()
+		if result.Err != nil {
+			r <- joinResult{err: ircError(result.Err)}
 			return
 		}
-		cancelChannelsContext()
 
-		joinPromises := make([]<-chan bool, 0, len(channels))
-		joinCtx, cancelJoinCtx := context.WithTimeout(e.ctx, timeoutMsec*time.Millisecond)
-		for _, channelName := range channels {
-			joinPromises = append(joinPromises, e.ircEngine.Join(joinCtx, channelName))
+		joinPromises := make([]<-chan error, 0, len(result.Channels))
+		joinCtx, cancelJoinCtx := context.WithCancel(n.ctx)
+		for _, channelName := range result.Channels {
+			joinPromises = append(joinPromises, n.ircEngine.Join(joinCtx, channelName))
 		}
-		for _, joinPromise := range joinPromises {
-			<-joinPromise
+		e.cancelAfterFlush(joinCtx, cancelJoinCtx, n.ircEngine.Flushed())
+		joined := joinResult{channels: make([]string, 0, len(joinPromises))}
+		for i, joinPromise := range joinPromises {
+			err := <-joinPromise
+			if err == nil {
+				joined.channels = append(joined.channels, result.Channels[i])
+			} else if joined.err == nil {
+				joined.err = ircError(err)
+			}
 		}
 
-		r <- true
+		r <- joined
 		cancelJoinCtx()
 	}()
 
 	return r
 }
 
-// RequestFile sends and memoizes download request.
-func (e *Engine) RequestFile(botNick string, packageNo int, fileName string) <-chan bool {
-	r := make(chan bool, 1)
+// cancelAfterFlush cancels ctx when no reply came within the timeout. The timeout starts
+// once flushed is closed, as queued lines may wait long for the flood limit.
+func (e *Engine) cancelAfterFlush(ctx context.Context, cancel context.CancelFunc, flushed <-chan struct{}) {
+	go func() {
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return
+		}
+
+		timer := time.NewTimer(e.timeout())
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// releaseIdle leaves channels joined for finished downloads of the network and stops
+// watching their bots unless active downloads still need them.
+func (e *Engine) releaseIdle(networkName string, n *network) {
+	e.downloadsMutex.Lock()
+	needed := map[string]bool{}
+	neededBots := map[string]bool{}
+	for _, download := range e.Downloads {
+		if download.active() && e.resolveNetwork(download.Network) == networkName {
+			for _, channelName := range download.channels {
+				needed[strings.ToLower(channelName)] = true
+			}
+			neededBots[strings.ToLower(download.BotNick)] = true
+		}
+	}
+	idle := []string{}
+	idleBots := []string{}
+	for _, download := range e.Downloads {
+		if download.active() || e.resolveNetwork(download.Network) != networkName {
+			continue
+		}
+		for _, channelName := range download.channels {
+			if !needed[strings.ToLower(channelName)] {
+				needed[strings.ToLower(channelName)] = true
+				idle = append(idle, channelName)
+			}
+		}
+		download.channels = nil
+		if download.BotNick != "" && !neededBots[strings.ToLower(download.BotNick)] {
+			neededBots[strings.ToLower(download.BotNick)] = true
+			idleBots = append(idleBots, download.BotNick)
+		}
+	}
+	e.downloadsMutex.Unlock()
+
+	for _, channelName := range idle {
+		n.ircEngine.Part(channelName)
+	}
+	for _, botNick := range idleBots {
+		n.ircEngine.Unwatch(botNick)
+	}
+}
+
+// handlePresence moves waiting downloads of a bot which went offline to WaitingForBot
+// and requests them again once the bot comes back. Downloads follow bots changing nicks.
+// Running transfers are not stopped, but end up in WaitingForBot when they fail.
+func (e *Engine) handlePresence(networkName string, n *network, packet irc.Packet) {
+	payload, payloadOk := packet.Payload.(irc.PresencePayload)
+	if !payloadOk {
+		return
+	}
+
+	type request struct {
+		fileName  string
+		botNick   string
+		packageNo int
+	}
+	requests := []request{}
+
+	e.downloadsMutex.Lock()
+	for fileName, download := range e.Downloads {
+		if !download.active() || !strings.EqualFold(download.BotNick, payload.Nick) || e.resolveNetwork(download.Network) != networkName {
+			continue
+		}
+
+		switch {
+		case payload.NewNick != "":
+			download.BotNick = payload.NewNick
+			if download.Status == WaitingForBot {
+				download.Status = Waiting
+				requests = append(requests, request{fileName, download.BotNick, download.PackageNo})
+			}
+		case !payload.Online && download.Status == Downloading:
+			download.botGone = true
+		case !payload.Online:
+			download.Status = WaitingForBot
+		case download.Status == WaitingForBot:
+			download.Status = Waiting
+			requests = append(requests, request{fileName, download.BotNick, download.PackageNo})
+		}
+	}
+	e.downloadsMutex.Unlock()
+
+	if payload.NewNick != "" {
+		n.ircEngine.Unwatch(payload.Nick)
+		n.ircEngine.Watch(payload.NewNick)
+	}
+	// Requests wait for replies handled by the caller, so they are not awaited.
+	for _, r := range requests {
+		e.RequestFile(networkName, r.botNick, r.packageNo, r.fileName)
+	}
+}
+
+// ircError returns err when it is an irc.IRCError, nil otherwise.
+func ircError(err error) error {
+	var ircErr irc.IRCError
+	if errors.As(err, &ircErr) {
+		return err
+	}
+
+	return nil
+}
+
+func (e *Engine) timeout() time.Duration {
+	if e.TimeoutMsec > 0 {
+		return time.Duration(e.TimeoutMsec) * time.Millisecond
+	}
+
+	return timeoutMsec * time.Millisecond
+}
+
+func (e *Engine) ssendFallback() time.Duration {
+	if e.SSendFallbackMsec > 0 {
+		return time.Duration(e.SSendFallbackMsec) * time.Millisecond
+	}
+
+	return ssendFallbackMsec * time.Millisecond
+}
+
+// RequestFile sends and memoizes download request on given network.
+// Empty network name means the default network.
+// Sends ErrUnknownNetwork on the returned channel when the network is unknown and an error
+// wrapping irc.ErrNoSuchNick when the bot is offline. Such downloads are kept as WaitingForBot.
+// Downloads are keyed by file name, so ErrFileNameTaken is sent while the name is active
+// on another network.
+func (e *Engine) RequestFile(networkName string, botNick string, packageNo int, fileName string) <-chan error {
+	r := make(chan error, 1)
+
+	networkName = e.resolveNetwork(networkName)
+	n, networkExists := e.network(networkName)
 
 	go func() {
 		defer close(r)
 
-		joinPromise := e.joinBotChannels(botNick)
-		<-joinPromise
+		if !networkExists {
+			r <- ErrUnknownNetwork
+			return
+		}
+
+		e.downloadsMutex.RLock()
+		taken := e.nameTaken(networkName, fileName)
+		e.downloadsMutex.RUnlock()
+		if taken {
+			r <- ErrFileNameTaken
+			return
+		}
+
+		joinPromise := e.joinBotChannels(n, botNick)
+		joined := <-joinPromise
 
-		e.ircEngine.SendMessage(botNick, fmt.Sprintf("XDCC SEND %d", packageNo))
+		download := &Download{
+			Status:    Waiting,
+			Network:   networkName,
+			BotNick:   botNick,
+			PackageNo: packageNo,
+			channels:  joined.channels,
+		}
+		if joined.err != nil {
+			download.Error = joined.err.Error()
+		}
+		if errors.Is(joined.err, irc.ErrNoSuchNick) {
+			download.Status = WaitingForBot
+			download.Error = "Bot is offline"
+		}
 
+		// Memoize before sending so that quick replies of the bot find the download.
+		// The name may have been taken while joining.
 		e.downloadsMutex.Lock()
-		e.Downloads[fileName] = &Download{Status: Waiting, BotNick: botNick, PackageNo: packageNo}
+		if e.nameTaken(networkName, fileName) {
+			e.downloadsMutex.Unlock()
+			r <- ErrFileNameTaken
+			return
+		}
+		e.Downloads[fileName] = download
 		e.downloadsMutex.Unlock()
 
-		r <- true
+		n.ircEngine.Watch(botNick)
+		if download.Status == WaitingForBot {
+			r <- joined.err
+			return
+		}
+
+		command := "XDCC SEND"
+		if e.Encryption[networkName] != EncryptionNone {
+			command = "XDCC SSEND"
+		}
+		n.ircEngine.SendMessage(botNick, fmt.Sprintf("%s %d", command, packageNo))
+		if e.Encryption[networkName] == EncryptionPrefer {
+			go e.fallBackToSend(n, fileName, download)
+		}
+
+		r <- nil
 	}()
 
 	return r
 }
 
+// nameTaken tells whether an active download on another network uses the file name.
+// The caller holds downloadsMutex.
+func (e *Engine) nameTaken(networkName string, fileName string) bool {
+	download, ok := e.Downloads[fileName]
+
+	return ok && download.active() && e.resolveNetwork(download.Network) != networkName
+}
+
+// fallBackToSend requests the package with plain XDCC SEND when the bot neither offered it
+// nor answered XDCC SSEND in time, as bots without SSEND support tend to ignore it.
+func (e *Engine) fallBackToSend(n *network, fileName string, download *Download) {
+	select {
+	case <-time.After(e.ssendFallback()):
+	case <-n.ctx.Done():
+		return
+	}
+
+	e.downloadsMutex.RLock()
+	unanswered := e.Downloads[fileName] == download && download.Status == Waiting &&
+		download.OfferedAt.IsZero() && download.BotMessage == ""
+	botNick, packageNo := download.BotNick, download.PackageNo
+	e.downloadsMutex.RUnlock()
+
+	if unanswered {
+		n.ircEngine.SendMessage(botNick, fmt.Sprintf("XDCC SEND %d", packageNo))
+	}
+}
+
 // DownloadsJSON writes JSON representation of downloads to given writer.
 func (e *Engine) DownloadsJSON(writer io.Writer) error {
 	e.downloadsMutex.RLock()
@@ -202,34 +702,85 @@ func (e *Engine) DownloadsJSON(writer io.Writer) error {
 	return json.NewEncoder(writer).Encode(jsonArray)
 }
 
-func (e *Engine) handleDccSendPacket(packet irc.Packet) {
+func (e *Engine) handleDccSendPacket(networkName string, n *network, packet irc.Packet) {
 	payload, payloadOk := packet.Payload.(irc.PrivMsgDccSendPayload)
 	if !payloadOk {
 		return
 	}
+	if payload.Passive() {
+		if !e.reservePassiveToken(payload.Token) {
+			return
+		}
+		defer e.releasePassiveToken(payload.Token)
+	}
 
 	e.downloadsMutex.RLock()
-	request, requestExists := e.Downloads[payload.FileName]
+	request, known := e.Downloads[payload.FileName]
+	requestExists := known && e.resolveNetwork(request.Network) == networkName
 	e.downloadsMutex.RUnlock()
 
-	if requestExists || e.UnsafeMode {
+	// Even in unsafe mode, offers do not take over downloads of other networks.
+	if requestExists || (e.UnsafeMode && !known) {
+		e.downloadsMutex.Lock()
+		if e.drainCtx != nil {
+			e.downloadsMutex.Unlock()
+			return
+		}
+		e.transfers.Add(1)
+		defer e.transfers.Done()
+
 		if !requestExists {
-			e.downloadsMutex.Lock()
-			e.Downloads[payload.FileName] = &Download{Status: Waiting}
+			if _, taken := e.Downloads[payload.FileName]; taken {
+				e.downloadsMutex.Unlock()
+				return
+			}
+			e.Downloads[payload.FileName] = &Download{Status: Waiting, Network: networkName}
 			request = e.Downloads[payload.FileName]
-			e.downloadsMutex.Unlock()
 		}
+		expected := request.Status == Waiting || request.Status == Deferred || request.Status == WaitingForBot
+		if expected {
+			offeredAt, ok := packet.Message.Time()
+			if !ok {
+				offeredAt = time.Now()
+			}
+			request.OfferedAt = offeredAt
+			request.BotAccount = packet.Message.Account()
+		}
+		e.downloadsMutex.Unlock()
 
-		if request.Status != Waiting {
+		if !expected {
 			return
 		}
 
-		downloadConn, dialError := e.dialer(e, payload)
+		ctx := n.ctx
+		var downloadConn io.ReadCloser
+		var dialError error
+		var position int64
+
+		switch {
+		case !payload.Secure && e.Encryption[networkName] == EncryptionRequire:
+			dialError = errors.New("Encryption is required but the bot offered plain DCC SEND")
+		case payload.Secure && payload.Passive():
+			dialError = errors.New("Passive DCC SSEND is not supported")
+		case payload.Passive():
+			position = e.negotiateResume(ctx, networkName, n, packet.Message.Prefix.Name, payload)
+			downloadConn, dialError = e.acceptPassive(ctx, n, packet.Message.Prefix.Name, payload)
+		default:
+			position = e.negotiateResume(ctx, networkName, n, packet.Message.Prefix.Name, payload)
+			downloadConn, dialError = DialTLS(e.dialerOf(networkName))(e, payload)
+		}
 		if dialError == nil {
 			defer downloadConn.Close()
 		}
 
-		writer, closer, writerErr := e.openWriter(e, payload)
+		var writer io.Writer
+		var closer io.Closer
+		var writerErr error
+		if position > 0 {
+			writer, closer, writerErr = e.Resumer.OpenAppend(e, payload, position)
+		} else {
+			writer, closer, writerErr = e.openWriter(e, payload)
+		}
 		if writerErr == nil {
 			defer closer.Close()
 		}
@@ -238,29 +789,37 @@ func (e *Engine) handleDccSendPacket(packet irc.Packet) {
 		if writerErr == nil && dialError == nil {
 			e.downloadsMutex.Lock()
 			e.Downloads[payload.FileName].Size = payload.FileLength
+			e.Downloads[payload.FileName].Downloaded = uint64(position)
 			e.Downloads[payload.FileName].Status = Downloading
 			e.downloadsMutex.Unlock()
 
 			wc := &WriteCounter{}
 			downloadReader := io.TeeReader(downloadConn, wc)
-			endSpeedOMeter := e.spawnSpeedOMeter(wc, payload)
+			endSpeedOMeter, speedOMeterEnded := e.spawnSpeedOMeter(wc, payload, uint64(position))
 			done := make(chan bool, 1)
 			defer close(done)
 
-			// Cancel download when context gets canceled
+			// Cancel download when context gets canceled, unless the engine is shutting down
+			// and the grace period has not passed yet. Closing the connection instead of the writer
+			// lets buffered data get flushed.
 			go func() {
 				select {
-				case <-e.ctx.Done():
-					closer.Close()
+				case <-ctx.Done():
+				case <-done:
+					return
+				}
+
+				select {
+				case <-e.drainContext().Done():
+					downloadConn.Close()
 				case <-done:
 				}
 			}()
 
-			_, copyErr = io.CopyN(writer, downloadReader, payload.FileLength)
+			_, copyErr = io.CopyN(writer, downloadReader, payload.FileLength-position)
 
-			if e.ctx.Err() == nil {
-				endSpeedOMeter <- true
-			}
+			endSpeedOMeter <- true
+			<-speedOMeterEnded
 			if flusher, isFlusher := writer.(interface{ Flush() error }); isFlusher {
 				flusher.Flush()
 			}
@@ -270,15 +829,83 @@ func (e *Engine) handleDccSendPacket(packet irc.Packet) {
 		e.downloadsMutex.Lock()
 		if copyErr == nil && writerErr == nil && dialError == nil {
 			e.Downloads[payload.FileName].Status = Done
+		} else if e.drainCtx != nil {
+			e.Downloads[payload.FileName].Status = Interrupted
+		} else if e.Downloads[payload.FileName].botGone {
+			e.Downloads[payload.FileName].Status = WaitingForBot
 		} else {
 			e.Downloads[payload.FileName].Status = Failed
 		}
+		draining := e.drainCtx != nil
 		e.downloadsMutex.Unlock()
+
+		if !draining {
+			e.releaseIdle(networkName, n)
+		}
+	}
+}
+
+// handleBotReply moves downloads requested from the sender of a recognized reply
+// to Failed or Deferred state. When the reply names a package, only that one is affected.
+// Channels and bots of failed downloads are released when no other download needs them.
+func (e *Engine) handleBotReply(networkName string, n *network, packet irc.Packet) {
+	payload, payloadOk := packet.Payload.(irc.TextPayload)
+	if !payloadOk || payload.Nick == "" {
+		return
+	}
+	reply, recognized := ClassifyBotReply(payload.Text)
+	if !recognized {
+		return
+	}
+
+	e.downloadsMutex.Lock()
+	for _, download := range e.Downloads {
+		if download.Status != Waiting && download.Status != Deferred {
+			continue
+		}
+		if !strings.EqualFold(download.BotNick, payload.Nick) || e.resolveNetwork(download.Network) != networkName {
+			continue
+		}
+		if reply.PackageNo != 0 && reply.PackageNo != download.PackageNo {
+			continue
+		}
+
+		download.BotMessage = reply.Message
+		if reply.Kind.Failed() {
+			download.Status = Failed
+			download.refused = true
+		} else {
+			download.Status = Deferred
+		}
+	}
+	e.downloadsMutex.Unlock()
+
+	if reply.Kind.Failed() {
+		e.releaseIdle(networkName, n)
 	}
 }
 
-func (e *Engine) spawnSpeedOMeter(wc *WriteCounter, payload irc.PrivMsgDccSendPayload) chan<- bool {
+// drainContext returns context that limits transfers after IRC connection is gone.
+// It is already canceled unless the engine is shutting down.
+func (e *Engine) drainContext() context.Context {
+	e.downloadsMutex.RLock()
+	defer e.downloadsMutex.RUnlock()
+
+	if e.drainCtx != nil {
+		return e.drainCtx
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// spawnSpeedOMeter updates download's progress every second. Progress starts at offset,
+// i.e. bytes stored before the transfer got resumed, which do not count towards speed.
+// Send on the first returned channel to stop it; the second one gets closed after the last update.
+func (e *Engine) spawnSpeedOMeter(wc *WriteCounter, payload irc.PrivMsgDccSendPayload, offset uint64) (chan<- bool, <-chan bool) {
 	done := make(chan bool, 1)
+	ended := make(chan bool)
 
 	startTime := time.Now()
 	lastTime := time.Now()
@@ -286,7 +913,7 @@ func (e *Engine) spawnSpeedOMeter(wc *WriteCounter, payload irc.PrivMsgDccSendPa
 	lastDownloadedBytes := float64(0)
 
 	go func() {
-		defer close(done)
+		defer close(ended)
 
 		ticker := time.NewTicker(time.Second)
 		defer ticker.Stop()
@@ -294,8 +921,6 @@ func (e *Engine) spawnSpeedOMeter(wc *WriteCounter, payload irc.PrivMsgDccSendPa
 		lastIteration := false
 		for {
 			select {
-			case <-e.ctx.Done():
-				lastIteration = true
 			case <-done:
 				lastIteration = true
 			case <-ticker.C:
@@ -323,7 +948,7 @@ func (e *Engine) spawnSpeedOMeter(wc *WriteCounter, payload irc.PrivMsgDccSendPa
 				e.Downloads[payload.FileName].CurrentSpeed = uint64(currentSpeed)
 			}
 			e.Downloads[payload.FileName].AvgSpeed = uint64(avgSpeed)
-			e.Downloads[payload.FileName].Downloaded = downloadedBytes
+			e.Downloads[payload.FileName].Downloaded = offset + downloadedBytes
 			e.downloadsMutex.Unlock()
 
 			if lastIteration {
@@ -332,5 +957,5 @@ func (e *Engine) spawnSpeedOMeter(wc *WriteCounter, payload irc.PrivMsgDccSendPa
 		}
 	}()
 
-	return done
+	return done, ended
 }
@@ -9,20 +9,31 @@ import (
 	"io"
 	"net"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// fakeIrcEngine records calls made by the engine. Recorded calls are read through
+// accessors, as the engine makes them from its goroutines.
 type fakeIrcEngine struct {
-	Channels     []string
-	SentMessages []string
 	PacketsChan  chan irc.Packet
 	ctx          context.Context
+	mutex        sync.Mutex
+	channels     []string
+	parted       []string
+	watched      []string
+	unwatched    []string
+	sentMessages []string
 }
 
 func (e *fakeIrcEngine) IRCPacketsChann() chan irc.Packet {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
 	if e.PacketsChan == nil {
 		e.PacketsChan = make(chan irc.Packet)
 	}
@@ -30,34 +41,56 @@ func (e *fakeIrcEngine) IRCPacketsChann() chan irc.Packet {
 	return e.PacketsChan
 }
 
-func (e *fakeIrcEngine) Join(ctx context.Context, channelName string) <-chan bool {
-	r := make(chan bool)
+func (e *fakeIrcEngine) Join(ctx context.Context, channelName string) <-chan error {
+	r := make(chan error)
 
 	go func() {
 		defer close(r)
-		e.Channels = append(e.Channels, channelName)
-		r <- true
+		e.record(&e.channels, channelName)
+		r <- nil
 	}()
 
 	return r
 }
 
-func (e *fakeIrcEngine) ChannelsOfUser(ctx context.Context, nick string) chan []string {
-	r := make(chan []string)
+func (e *fakeIrcEngine) ChannelsOfUser(ctx context.Context, nick string) <-chan irc.ChannelsResult {
+	r := make(chan irc.ChannelsResult)
 
 	go func() {
 		defer close(r)
-		r <- []string{"foo", "bar"}
+		r <- irc.ChannelsResult{Channels: []string{"foo", "bar"}}
 	}()
 
 	return r
 }
 
+func (e *fakeIrcEngine) Flushed() <-chan struct{} {
+	flushed := make(chan struct{})
+	close(flushed)
+
+	return flushed
+}
+
+func (e *fakeIrcEngine) Part(channelName string) {
+	e.record(&e.parted, channelName)
+}
+
+func (e *fakeIrcEngine) Watch(nick string) {
+	e.record(&e.watched, nick)
+}
+
+func (e *fakeIrcEngine) Unwatch(nick string) {
+	e.record(&e.unwatched, nick)
+}
+
 func (e *fakeIrcEngine) SendMessage(nick string, body string) {
-	e.SentMessages = append(e.SentMessages, body)
+	e.record(&e.sentMessages, body)
 }
 
 func (e *fakeIrcEngine) Context() context.Context {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
 	if e.ctx == nil {
 		e.ctx = context.Background()
 	}
@@ -65,6 +98,44 @@ func (e *fakeIrcEngine) Context() context.Context {
 	return e.ctx
 }
 
+func (e *fakeIrcEngine) record(calls *[]string, value string) {
+	e.mutex.Lock()
+	*calls = append(*calls, value)
+	e.mutex.Unlock()
+}
+
+func (e *fakeIrcEngine) recorded(calls *[]string) []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]string(nil), *calls...)
+}
+
+// Joined returns channels joined so far.
+func (e *fakeIrcEngine) Joined() []string {
+	return e.recorded(&e.channels)
+}
+
+// Parted returns channels parted so far.
+func (e *fakeIrcEngine) Parted() []string {
+	return e.recorded(&e.parted)
+}
+
+// Watched returns nicks watched so far.
+func (e *fakeIrcEngine) Watched() []string {
+	return e.recorded(&e.watched)
+}
+
+// Unwatched returns nicks unwatched so far.
+func (e *fakeIrcEngine) Unwatched() []string {
+	return e.recorded(&e.unwatched)
+}
+
+// SentMessages returns bodies of messages sent so far.
+func (e *fakeIrcEngine) SentMessages() []string {
+	return e.recorded(&e.sentMessages)
+}
+
 type FakeReadCloser struct {
 	Closed bool
 }
@@ -148,14 +219,15 @@ func TestRequestFile(t *testing.T) {
 	engine := &Engine{}
 
 	dial, prepareWriter, _ := PrepareFakes()
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 
-	requestPromise := engine.RequestFile("b0t", 42, "foo.bar")
+	requestPromise := engine.RequestFile("foo", "b0t", 42, "foo.bar")
 	<-requestPromise
 
-	assert.Contains(t, ircEngine.Channels, "foo")
-	assert.Contains(t, ircEngine.Channels, "bar")
-	assert.Contains(t, ircEngine.SentMessages[0], "XDCC SEND 42")
+	assert.Contains(t, ircEngine.Joined(), "foo")
+	assert.Contains(t, ircEngine.Joined(), "bar")
+	assert.Contains(t, ircEngine.SentMessages()[0], "XDCC SEND 42")
 	download, downloadExists := engine.Downloads["foo.bar"]
 	assert.True(t, downloadExists)
 	assert.Equal(t, Waiting, download.Status)
@@ -167,9 +239,10 @@ func TestHandleDccSend(t *testing.T) {
 
 	engine := &Engine{}
 	dial, prepareWriter, fakes := PrepareFakes()
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 
-	requestPromise := engine.RequestFile("b0t", 42, "foo.bar")
+	requestPromise := engine.RequestFile("foo", "b0t", 42, "foo.bar")
 	<-requestPromise
 
 	payload := irc.PrivMsgDccSendPayload{
@@ -196,7 +269,8 @@ func TestHandleDccSendDoesnExist(t *testing.T) {
 
 	engine := &Engine{}
 	dial, prepareWriter, _ := PrepareFakes()
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 
 	payload := irc.PrivMsgDccSendPayload{
 		FileName:   "foo.bar",
@@ -217,7 +291,8 @@ func TestHandleDccSendDoesnExistUnsafe(t *testing.T) {
 
 	engine := &Engine{}
 	dial, prepareWriter, _ := PrepareFakes()
-	engine.Start(ircEngine, dial, prepareWriter, true)
+	engine.Start(dial, prepareWriter, true)
+	engine.AddNetwork("foo", ircEngine)
 
 	payload := irc.PrivMsgDccSendPayload{
 		FileName:   "foo.bar",
@@ -242,9 +317,10 @@ func TestHandleDccSendDialErr(t *testing.T) {
 	dial := func(*Engine, irc.PrivMsgDccSendPayload) (io.ReadCloser, error) {
 		return nil, errors.New("")
 	}
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 
-	requestPromise := engine.RequestFile("b0t", 42, "foo.bar")
+	requestPromise := engine.RequestFile("foo", "b0t", 42, "foo.bar")
 	<-requestPromise
 
 	payload := irc.PrivMsgDccSendPayload{
@@ -270,9 +346,10 @@ func TestHandleDccSendOpenWriterErr(t *testing.T) {
 	prepareWriter := func(engine *Engine, payload irc.PrivMsgDccSendPayload) (io.Writer, io.Closer, error) {
 		return nil, nil, errors.New("")
 	}
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 
-	requestPromise := engine.RequestFile("b0t", 42, "foo.bar")
+	requestPromise := engine.RequestFile("foo", "b0t", 42, "foo.bar")
 	<-requestPromise
 
 	payload := irc.PrivMsgDccSendPayload{
@@ -298,9 +375,10 @@ func TestHandleDccSendCopyErr(t *testing.T) {
 		return &ErrReader{}, nil
 	}
 	engine := &Engine{}
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 
-	requestPromise := engine.RequestFile("b0t", 42, "foo.bar")
+	requestPromise := engine.RequestFile("foo", "b0t", 42, "foo.bar")
 	<-requestPromise
 
 	payload := irc.PrivMsgDccSendPayload{
@@ -323,9 +401,10 @@ func TestDownloadsJSON(t *testing.T) {
 
 	dial, prepareWriter, _ := PrepareFakes()
 	engine := &Engine{}
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 
-	requestPromise := engine.RequestFile("b0t", 42, "foo.bar")
+	requestPromise := engine.RequestFile("foo", "b0t", 42, "foo.bar")
 	<-requestPromise
 
 	payload := irc.PrivMsgDccSendPayload{
@@ -354,7 +433,8 @@ func TestContextAndStop(t *testing.T) {
 	ircEngine := &fakeIrcEngine{}
 	dial, prepareWriter, _ := PrepareFakes()
 	engine := &Engine{}
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 	ctx := engine.Context()
 	engine.Stop()
 
@@ -366,7 +446,8 @@ func TestRestartResumesDownloads(t *testing.T) {
 	dial, prepareWriter, _ := PrepareFakes()
 	engine := &Engine{}
 
-	engine.Start(ircEngine, dial, prepareWriter, false)
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
 
 	engine.Downloads = map[string]*Download{
 		"foo.mkv": &Download{
@@ -393,19 +474,664 @@ func TestRestartResumesDownloads(t *testing.T) {
 			PackageNo: 4,
 			Size:      4000,
 		},
+		"y.mkv": &Download{
+			Status:     Failed,
+			BotNick:    "b0t",
+			PackageNo:  5,
+			BotMessage: "Invalid Pack Number",
+			refused:    true,
+		},
 	}
 
-	engine.Restart(ircEngine)
+	engine.Restart("foo", ircEngine)
 
 	assert.Equal(t, engine.Downloads["foo.mkv"].Status, Waiting)
-	assert.Contains(t, ircEngine.SentMessages, "XDCC SEND 1")
+	assert.Contains(t, ircEngine.SentMessages(), "XDCC SEND 1")
 
 	assert.Equal(t, engine.Downloads["bar.mkv"].Status, Waiting)
-	assert.Contains(t, ircEngine.SentMessages, "XDCC SEND 2")
+	assert.Contains(t, ircEngine.SentMessages(), "XDCC SEND 2")
 
 	assert.Equal(t, engine.Downloads["baz.mkv"].Status, Waiting)
-	assert.Contains(t, ircEngine.SentMessages, "XDCC SEND 3")
+	assert.Contains(t, ircEngine.SentMessages(), "XDCC SEND 3")
 
 	assert.Equal(t, engine.Downloads["x.mkv"].Status, Done)
-	assert.NotContains(t, ircEngine.SentMessages, "XDCC SEND 4")
+	assert.NotContains(t, ircEngine.SentMessages(), "XDCC SEND 4")
+
+	assert.Equal(t, engine.Downloads["y.mkv"].Status, Failed)
+	assert.NotContains(t, ircEngine.SentMessages(), "XDCC SEND 5")
+}
+
+// GatedReadCloser blocks reading until the gate gets opened or the reader gets closed.
+type GatedReadCloser struct {
+	gate   chan bool
+	closed chan bool
+}
+
+func NewGatedReadCloser() *GatedReadCloser {
+	return &GatedReadCloser{gate: make(chan bool), closed: make(chan bool)}
+}
+
+func (grc *GatedReadCloser) Read(p []byte) (n int, err error) {
+	select {
+	case <-grc.gate:
+		p[0] = 'A'
+		return 1, nil
+	case <-grc.closed:
+		return 0, errors.New("closed")
+	}
+}
+
+func (grc *GatedReadCloser) Close() error {
+	select {
+	case <-grc.closed:
+	default:
+		close(grc.closed)
+	}
+
+	return nil
+}
+
+func startGatedTransfer(ircEngine *fakeIrcEngine) (*Engine, *GatedReadCloser, *FakeIOs) {
+	packetsChann := ircEngine.IRCPacketsChann()
+	reader := NewGatedReadCloser()
+	_, prepareWriter, fakes := PrepareFakes()
+	dial := func(*Engine, irc.PrivMsgDccSendPayload) (io.ReadCloser, error) {
+		return reader, nil
+	}
+
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+
+	payload := irc.PrivMsgDccSendPayload{
+		FileName:   "foo.bar",
+		FileLength: 50,
+		IP:         net.ParseIP("127.0.0.1"),
+		Port:       1337,
+	}
+	packetsChann <- irc.Packet{Type: irc.PrivMsgDccSend, Payload: payload}
+	time.Sleep(50 * time.Millisecond)
+
+	return engine, reader, fakes
+}
+
+func TestShutdownWaitsForTransfers(t *testing.T) {
+	ircCtx, cancelIrc := context.WithCancel(context.Background())
+	ircEngine := &fakeIrcEngine{ctx: ircCtx}
+	engine, reader, fakes := startGatedTransfer(ircEngine)
+
+	shutdownPromise := engine.Shutdown(context.Background())
+	cancelIrc()
+	time.Sleep(20 * time.Millisecond)
+	close(reader.gate)
+
+	<-shutdownPromise
+	assert.Equal(t, Done, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, 50, fakes.fw.BytesWritten)
+	<-engine.Context().Done()
+}
+
+func TestShutdownInterruptsAfterGracePeriod(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	engine, reader, fakes := startGatedTransfer(ircEngine)
+	engine.downloadsMutex.Lock()
+	engine.Downloads["bar.baz"] = &Download{Status: Waiting, BotNick: "b0t", PackageNo: 2}
+	engine.downloadsMutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	<-engine.Shutdown(ctx)
+
+	engine.downloadsMutex.RLock()
+	assert.Equal(t, Interrupted, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, Interrupted, engine.Downloads["bar.baz"].Status)
+	engine.downloadsMutex.RUnlock()
+	assert.True(t, fakes.fw.Flushed)
+	assert.True(t, fakes.fw.Closed)
+	<-reader.closed
+}
+
+func TestLoadDownloads(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	err := engine.LoadDownloads(bytes.NewBufferString(
+		fmt.Sprintf(`[{"FileName":"foo.mkv","Status":%d,"BotNick":"b0t","PackageNo":1,"Size":10}]`, Interrupted),
+	))
+
+	assert.Nil(t, err)
+	assert.Equal(t, Interrupted, engine.Downloads["foo.mkv"].Status)
+	assert.Equal(t, "b0t", engine.Downloads["foo.mkv"].BotNick)
+	assert.Equal(t, int64(10), engine.Downloads["foo.mkv"].Size)
+}
+
+func TestLoadDownloadsNotJSON(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	err := engine.LoadDownloads(bytes.NewBufferString("foo"))
+
+	assert.NotNil(t, err)
+}
+
+func TestRequestInterrupted(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+	engine.Downloads = map[string]*Download{
+		"foo.mkv": &Download{Status: Interrupted, BotNick: "b0t", PackageNo: 1},
+		"bar.mkv": &Download{Status: Failed, BotNick: "b0t", PackageNo: 2},
+		"baz.mkv": &Download{Status: Interrupted},
+	}
+
+	<-engine.RequestInterrupted()
+
+	assert.Equal(t, []string{"XDCC SEND 1"}, ircEngine.SentMessages())
+	assert.Equal(t, Waiting, engine.Downloads["foo.mkv"].Status)
+	assert.Equal(t, Failed, engine.Downloads["bar.mkv"].Status)
+}
+
+func TestRequestFileUnknownNetwork(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	engine := &Engine{}
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	assert.Equal(t, ErrUnknownNetwork, <-engine.RequestFile("bar", "b0t", 42, "foo.bar"))
+	assert.Empty(t, ircEngine.SentMessages())
+	assert.Empty(t, engine.Downloads)
+}
+
+func TestRequestFileNameTakenOnOtherNetwork(t *testing.T) {
+	fooEngine := &fakeIrcEngine{}
+	barEngine := &fakeIrcEngine{}
+	engine := &Engine{}
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", fooEngine)
+	engine.AddNetwork("bar", barEngine)
+	original := &Download{Status: Downloading, Network: "foo", BotNick: "b0t", PackageNo: 1}
+	engine.Downloads = map[string]*Download{"foo.bar": original}
+
+	assert.Equal(t, ErrFileNameTaken, <-engine.RequestFile("bar", "b0t", 42, "foo.bar"))
+	assert.Same(t, original, engine.Downloads["foo.bar"])
+	assert.Empty(t, barEngine.SentMessages())
+
+	// The name is free again once the download on the other network finishes.
+	original.Status = Done
+	assert.Nil(t, <-engine.RequestFile("bar", "b0t", 42, "foo.bar"))
+	assert.Equal(t, "bar", engine.Downloads["foo.bar"].Network)
+	assert.Equal(t, []string{"XDCC SEND 42"}, barEngine.SentMessages())
+}
+
+// refusingIrcEngine fails WHOIS or joins with given errors.
+type refusingIrcEngine struct {
+	fakeIrcEngine
+	channelsErr error
+	joinErr     error
+}
+
+func (e *refusingIrcEngine) ChannelsOfUser(ctx context.Context, nick string) <-chan irc.ChannelsResult {
+	r := make(chan irc.ChannelsResult, 1)
+	r <- irc.ChannelsResult{Channels: []string{"foo"}, Err: e.channelsErr}
+	close(r)
+
+	return r
+}
+
+func (e *refusingIrcEngine) Join(ctx context.Context, channelName string) <-chan error {
+	r := make(chan error, 1)
+	r <- e.joinErr
+	close(r)
+
+	return r
+}
+
+// queuedIrcEngine answers WHOIS only once its queue is flushed.
+type queuedIrcEngine struct {
+	fakeIrcEngine
+	flushed chan struct{}
+}
+
+func (e *queuedIrcEngine) ChannelsOfUser(ctx context.Context, nick string) <-chan irc.ChannelsResult {
+	r := make(chan irc.ChannelsResult, 1)
+
+	go func() {
+		defer close(r)
+		select {
+		case <-e.flushed:
+			r <- irc.ChannelsResult{Channels: []string{"foo"}}
+		case <-ctx.Done():
+			r <- irc.ChannelsResult{Channels: []string{}, Err: ctx.Err()}
+		}
+	}()
+
+	return r
+}
+
+func (e *queuedIrcEngine) Flushed() <-chan struct{} {
+	return e.flushed
+}
+
+func TestRequestFileTimeoutStartsOnceFlushed(t *testing.T) {
+	ircEngine := &queuedIrcEngine{flushed: make(chan struct{})}
+	engine := &Engine{TimeoutMsec: 10}
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	time.AfterFunc(50*time.Millisecond, func() { close(ircEngine.flushed) })
+
+	assert.Nil(t, <-engine.RequestFile("foo", "b0t", 42, "foo.bar"))
+	assert.Equal(t, []string{"foo"}, ircEngine.Joined())
+	assert.Equal(t, []string{"XDCC SEND 42"}, ircEngine.SentMessages())
+}
+
+func TestRequestFileBotOffline(t *testing.T) {
+	ircEngine := &refusingIrcEngine{channelsErr: irc.IRCError{Code: "401", Target: "b0t"}}
+	engine := &Engine{}
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	assert.True(t, errors.Is(<-engine.RequestFile("foo", "b0t", 42, "foo.bar"), irc.ErrNoSuchNick))
+
+	assert.Empty(t, ircEngine.SentMessages())
+	assert.Equal(t, []string{"b0t"}, ircEngine.Watched())
+	assert.Equal(t, WaitingForBot, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, "Bot is offline", engine.Downloads["foo.bar"].Error)
+}
+
+func TestRequestFileJoinRefused(t *testing.T) {
+	ircEngine := &refusingIrcEngine{joinErr: irc.IRCError{Code: "477", Target: "#foo"}}
+	engine := &Engine{}
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+
+	assert.Contains(t, ircEngine.SentMessages()[0], "XDCC SEND 42")
+	assert.Equal(t, Waiting, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, "#foo: Channel requires registered nick", engine.Downloads["foo.bar"].Error)
+}
+
+func TestRequestFileIgnoresTimeouts(t *testing.T) {
+	ircEngine := &refusingIrcEngine{channelsErr: context.DeadlineExceeded}
+	engine := &Engine{}
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+
+	assert.Contains(t, ircEngine.SentMessages()[0], "XDCC SEND 42")
+	assert.Empty(t, engine.Downloads["foo.bar"].Error)
+}
+
+func TestRequestFileDefaultNetwork(t *testing.T) {
+	fooIrcEngine := &fakeIrcEngine{}
+	barIrcEngine := &fakeIrcEngine{}
+	engine := &Engine{DefaultNetwork: "bar"}
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", fooIrcEngine)
+	engine.AddNetwork("bar", barIrcEngine)
+
+	assert.Nil(t, <-engine.RequestFile("", "b0t", 42, "foo.bar"))
+	assert.Empty(t, fooIrcEngine.SentMessages())
+	assert.Equal(t, []string{"XDCC SEND 42"}, barIrcEngine.SentMessages())
+	assert.Equal(t, "bar", engine.Downloads["foo.bar"].Network)
+}
+
+func TestHandleDccSendFromOtherNetwork(t *testing.T) {
+	fooIrcEngine := &fakeIrcEngine{}
+	barIrcEngine := &fakeIrcEngine{}
+	barPacketsChann := barIrcEngine.IRCPacketsChann()
+
+	engine := &Engine{}
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", fooIrcEngine)
+	engine.AddNetwork("bar", barIrcEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+
+	payload := irc.PrivMsgDccSendPayload{
+		FileName:   "foo.bar",
+		FileLength: 50,
+		IP:         net.ParseIP("127.0.0.1"),
+		Port:       1337,
+	}
+	barPacketsChann <- irc.Packet{Type: irc.PrivMsgDccSend, Payload: payload}
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, Waiting, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, "foo", engine.Downloads["foo.bar"].Network)
+}
+
+func TestHandleDccSendFromOtherNetworkUnsafe(t *testing.T) {
+	fooIrcEngine := &fakeIrcEngine{}
+	barIrcEngine := &fakeIrcEngine{}
+	barPacketsChann := barIrcEngine.IRCPacketsChann()
+
+	engine := &Engine{}
+	dial, prepareWriter, _ := PrepareFakes()
+	engine.Start(dial, prepareWriter, true)
+	engine.AddNetwork("foo", fooIrcEngine)
+	engine.AddNetwork("bar", barIrcEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+
+	payload := irc.PrivMsgDccSendPayload{
+		FileName:   "foo.bar",
+		FileLength: 50,
+		IP:         net.ParseIP("127.0.0.1"),
+		Port:       1337,
+	}
+	barPacketsChann <- irc.Packet{Type: irc.PrivMsgDccSend, Payload: payload}
+	time.Sleep(50 * time.Millisecond)
+
+	engine.downloadsMutex.RLock()
+	assert.Equal(t, Waiting, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, "foo", engine.Downloads["foo.bar"].Network)
+	engine.downloadsMutex.RUnlock()
+}
+
+func TestRestartResumesOnlyDownloadsOfTheNetwork(t *testing.T) {
+	fooIrcEngine := &fakeIrcEngine{}
+	barIrcEngine := &fakeIrcEngine{}
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", fooIrcEngine)
+	engine.AddNetwork("bar", barIrcEngine)
+
+	engine.Downloads = map[string]*Download{
+		"foo.mkv": &Download{Status: Failed, Network: "foo", BotNick: "b0t", PackageNo: 1},
+		"bar.mkv": &Download{Status: Failed, Network: "bar", BotNick: "b0t", PackageNo: 2},
+	}
+
+	newFooIrcEngine := &fakeIrcEngine{}
+	engine.Restart("foo", newFooIrcEngine)
+
+	assert.Equal(t, []string{"XDCC SEND 1"}, newFooIrcEngine.SentMessages())
+	assert.Empty(t, barIrcEngine.SentMessages())
+	assert.Equal(t, Waiting, engine.Downloads["foo.mkv"].Status)
+	assert.Equal(t, Failed, engine.Downloads["bar.mkv"].Status)
+}
+
+func TestHandleDccSendUsesDialerOfTheNetwork(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	networkDialed := make(chan bool, 1)
+	networkDial := func(engine *Engine, payload irc.PrivMsgDccSendPayload) (io.ReadCloser, error) {
+		networkDialed <- true
+		return dial(engine, payload)
+	}
+	engine := &Engine{Dialers: map[string]Dialer{"foo": networkDial}}
+	engine.Start(func(*Engine, irc.PrivMsgDccSendPayload) (io.ReadCloser, error) {
+		return nil, errors.New("")
+	}, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+
+	payload := irc.PrivMsgDccSendPayload{
+		FileName:   "foo.bar",
+		FileLength: 50,
+		IP:         net.ParseIP("127.0.0.1"),
+		Port:       1337,
+	}
+	packetsChann <- irc.Packet{Type: irc.PrivMsgDccSend, Payload: payload}
+
+	select {
+	case <-networkDialed:
+	case <-time.After(time.Second):
+		t.Fatal("Did not use dialer of the network")
+	}
+}
+
+func TestHandleDccSendRecordsOfferTagsFromServer(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+
+	packetsChann <- irc.Parse("@time=2020-05-01T12:30:00.000Z;account=b0tacc :b0t!b@h PRIVMSG ownadi :\x01DCC SEND foo.bar 2130706433 1337 50\x01")
+
+	assert.Eventually(t, func() bool {
+		engine.downloadsMutex.RLock()
+		defer engine.downloadsMutex.RUnlock()
+		return !engine.Downloads["foo.bar"].OfferedAt.IsZero()
+	}, time.Second, time.Millisecond)
+
+	engine.downloadsMutex.RLock()
+	defer engine.downloadsMutex.RUnlock()
+	assert.Equal(t, time.Date(2020, 5, 1, 12, 30, 0, 0, time.UTC), engine.Downloads["foo.bar"].OfferedAt)
+	assert.Equal(t, "b0tacc", engine.Downloads["foo.bar"].BotAccount)
+}
+
+func TestBotReplyFailsDownload(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	<-engine.RequestFile("foo", "b0t", 43, "bar.baz")
+	<-engine.RequestFile("foo", "other", 42, "baz.qux")
+
+	packetsChann <- irc.Parse(":b0t!b@h NOTICE ownadi :** Invalid Pack Number, Try Again")
+	packetsChann <- irc.Parse(":b0t!b@h PRIVMSG ownadi :Hello!")
+
+	engine.downloadsMutex.RLock()
+	defer engine.downloadsMutex.RUnlock()
+	assert.Equal(t, Failed, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, "** Invalid Pack Number, Try Again", engine.Downloads["foo.bar"].BotMessage)
+	assert.Equal(t, Failed, engine.Downloads["bar.baz"].Status)
+	assert.Equal(t, Waiting, engine.Downloads["baz.qux"].Status)
+}
+
+func TestFailedDownloadPartsIdleChannels(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	<-engine.RequestFile("foo", "other", 43, "bar.baz")
+
+	packetsChann <- irc.Parse(":b0t!b@h NOTICE ownadi :** Invalid Pack Number, Try Again")
+	packetsChann <- irc.Parse(":b0t!b@h PRIVMSG ownadi :Hello!")
+
+	assert.Empty(t, ircEngine.Parted())
+
+	packetsChann <- irc.Parse(":other!b@h NOTICE ownadi :** Invalid Pack Number, Try Again")
+	packetsChann <- irc.Parse(":other!b@h PRIVMSG ownadi :Hello!")
+
+	assert.ElementsMatch(t, []string{"foo", "bar"}, ircEngine.Parted())
+	assert.Contains(t, ircEngine.Unwatched(), "b0t")
+	assert.Contains(t, ircEngine.Unwatched(), "other")
+}
+
+func presencePacket(payload irc.PresencePayload) irc.Packet {
+	return irc.Packet{Type: irc.Presence, Payload: payload}
+}
+
+func TestBotGoingOfflineParksDownloads(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	<-engine.RequestFile("foo", "other", 43, "bar.baz")
+
+	packetsChann <- presencePacket(irc.PresencePayload{Nick: "B0T", Online: false})
+	packetsChann <- irc.Parse(":other!b@h PRIVMSG ownadi :Hello!")
+
+	engine.downloadsMutex.RLock()
+	assert.Equal(t, WaitingForBot, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, Waiting, engine.Downloads["bar.baz"].Status)
+	engine.downloadsMutex.RUnlock()
+}
+
+func TestBotComingBackRequestsAgain(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	packetsChann <- presencePacket(irc.PresencePayload{Nick: "b0t", Online: false})
+	packetsChann <- presencePacket(irc.PresencePayload{Nick: "b0t", Online: true})
+
+	assert.Eventually(t, func() bool {
+		return strings.Count(strings.Join(ircEngine.SentMessages(), "\n"), "XDCC SEND 42") == 2
+	}, time.Second, time.Millisecond)
+	engine.downloadsMutex.RLock()
+	assert.Equal(t, Waiting, engine.Downloads["foo.bar"].Status)
+	engine.downloadsMutex.RUnlock()
+}
+
+func TestDownloadsFollowBotNickChange(t *testing.T) {
+	ircEngine := &refusingIrcEngine{channelsErr: irc.IRCError{Code: "401", Target: "b0t"}}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	ircEngine.channelsErr = nil
+	packetsChann <- presencePacket(irc.PresencePayload{Nick: "b0t", Online: false, NewNick: "b0t|back"})
+
+	assert.Eventually(t, func() bool {
+		engine.downloadsMutex.RLock()
+		defer engine.downloadsMutex.RUnlock()
+
+		return engine.Downloads["foo.bar"].BotNick == "b0t|back" && engine.Downloads["foo.bar"].Status == Waiting
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"b0t"}, ircEngine.Unwatched())
+	assert.Contains(t, ircEngine.Watched(), "b0t|back")
+}
+
+func TestBotReplyDefersDownloadOfThePackage(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, fakeIOs := PrepareFakes()
+	engine := &Engine{}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	<-engine.RequestFile("foo", "b0t", 43, "bar.baz")
+
+	packetsChann <- irc.Parse(":B0T!b@h NOTICE ownadi :** All Slots Full, Added you to the main queue for pack 43 (\"bar.baz\") in position 1.")
+	packetsChann <- irc.Parse(":b0t!b@h PRIVMSG ownadi :Hello!")
+
+	engine.downloadsMutex.RLock()
+	assert.Equal(t, Waiting, engine.Downloads["foo.bar"].Status)
+	assert.Equal(t, Deferred, engine.Downloads["bar.baz"].Status)
+	assert.Contains(t, engine.Downloads["bar.baz"].BotMessage, "All Slots Full")
+	engine.downloadsMutex.RUnlock()
+
+	payload := irc.PrivMsgDccSendPayload{FileName: "bar.baz", FileLength: 50, IP: net.ParseIP("127.0.0.1"), Port: 1337}
+	packetsChann <- irc.Packet{Type: irc.PrivMsgDccSend, Payload: payload}
+
+	assert.Eventually(t, func() bool {
+		engine.downloadsMutex.RLock()
+		defer engine.downloadsMutex.RUnlock()
+		return engine.Downloads["bar.baz"].Status == Done
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 50, fakeIOs.fw.BytesWritten)
+}
+
+func TestPreferredEncryptionFallsBackToSend(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{Encryption: map[string]Encryption{"foo": EncryptionPrefer}, SSendFallbackMsec: 10}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+
+	assert.Eventually(t, func() bool { return len(ircEngine.SentMessages()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"XDCC SSEND 42", "XDCC SEND 42"}, ircEngine.SentMessages())
+}
+
+func TestPreferredEncryptionKeepsAnsweredSsend(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{Encryption: map[string]Encryption{"foo": EncryptionPrefer}, SSendFallbackMsec: 50}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	packetsChann <- irc.Parse(":b0t!b@h NOTICE ownadi :** All Slots Full, Added you to the main queue for pack 42 in position 1.")
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, []string{"XDCC SSEND 42"}, ircEngine.SentMessages())
+}
+
+func TestEncryptionPolicy(t *testing.T) {
+	ircEngine := &fakeIrcEngine{}
+	packetsChann := ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, _ := PrepareFakes()
+	engine := &Engine{Encryption: map[string]Encryption{"foo": EncryptionRequire}}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	assert.Equal(t, []string{"XDCC SSEND 42"}, ircEngine.SentMessages())
+
+	payload := irc.PrivMsgDccSendPayload{FileName: "foo.bar", FileLength: 50, IP: net.ParseIP("127.0.0.1"), Port: 1337}
+	packetsChann <- irc.Packet{Type: irc.PrivMsgDccSend, Payload: payload}
+
+	assert.Eventually(t, func() bool { return downloadStatus(engine, "foo.bar") == Failed }, time.Second, time.Millisecond)
 }
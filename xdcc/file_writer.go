@@ -0,0 +1,90 @@
+package xdcc
+
+import (
+	"animuxd/irc"
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A Resumer gives access to partially downloaded files.
+type Resumer interface {
+	// Position returns how many bytes of the offered file are already stored.
+	Position(engine *Engine, payload irc.PrivMsgDccSendPayload) int64
+	// OpenAppend opens the file for writing at given position, dropping anything after it.
+	OpenAppend(engine *Engine, payload irc.PrivMsgDccSendPayload, position int64) (io.Writer, io.Closer, error)
+}
+
+// FileWriteOpener returns a WriteOpener that stores requested files
+// in the given directory. Returned writer is buffered.
+func FileWriteOpener(dir string) WriteOpener {
+	return func(engine *Engine, payload irc.PrivMsgDccSendPayload) (io.Writer, io.Closer, error) {
+		path, err := filePath(dir, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return bufio.NewWriter(file), file, nil
+	}
+}
+
+// FileResumer returns a Resumer of files stored by FileWriteOpener in the given directory.
+func FileResumer(dir string) Resumer {
+	return fileResumer{dir: dir}
+}
+
+type fileResumer struct {
+	dir string
+}
+
+func (r fileResumer) Position(engine *Engine, payload irc.PrivMsgDccSendPayload) int64 {
+	path, err := filePath(r.dir, payload)
+	if err != nil {
+		return 0
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+
+	return info.Size()
+}
+
+func (r fileResumer) OpenAppend(engine *Engine, payload irc.PrivMsgDccSendPayload, position int64) (io.Writer, io.Closer, error) {
+	path, err := filePath(r.dir, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = file.Truncate(position); err == nil {
+		_, err = file.Seek(position, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return bufio.NewWriter(file), file, nil
+}
+
+// filePath returns where the offered file is stored. Directories sent by the bot are dropped.
+func filePath(dir string, payload irc.PrivMsgDccSendPayload) (string, error) {
+	fileName := filepath.Base(payload.FileName)
+	if fileName == "." || fileName == ".." || fileName == string(filepath.Separator) {
+		return "", errors.New("Invalid file name")
+	}
+
+	return filepath.Join(dir, fileName), nil
+}
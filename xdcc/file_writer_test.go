@@ -0,0 +1,60 @@
+package xdcc
+
+import (
+	"animuxd/irc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileWriteOpener(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+
+	openWriter := FileWriteOpener(dir)
+	writer, closer, err := openWriter(&Engine{}, irc.PrivMsgDccSendPayload{FileName: "../foo.bar"})
+	assert.Nil(t, err)
+
+	writer.Write([]byte("foo"))
+	writer.(interface{ Flush() error }).Flush()
+	closer.Close()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "foo.bar"))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", string(data))
+}
+
+func TestFileWriteOpenerInvalidName(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+
+	openWriter := FileWriteOpener(dir)
+	_, _, err := openWriter(&Engine{}, irc.PrivMsgDccSendPayload{FileName: ".."})
+	assert.NotNil(t, err)
+}
+
+func TestFileResumer(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "animuxd")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "foo.bar"), []byte("foobar"), 0644)
+
+	resumer := FileResumer(dir)
+	payload := irc.PrivMsgDccSendPayload{FileName: "foo.bar"}
+
+	assert.Equal(t, int64(6), resumer.Position(&Engine{}, payload))
+	assert.Equal(t, int64(0), resumer.Position(&Engine{}, irc.PrivMsgDccSendPayload{FileName: "bar.baz"}))
+	assert.Equal(t, int64(0), resumer.Position(&Engine{}, irc.PrivMsgDccSendPayload{FileName: ".."}))
+
+	writer, closer, err := resumer.OpenAppend(&Engine{}, payload, 3)
+	assert.Nil(t, err)
+
+	writer.Write([]byte("baz"))
+	writer.(interface{ Flush() error }).Flush()
+	closer.Close()
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "foo.bar"))
+	assert.Equal(t, "foobaz", string(data))
+}
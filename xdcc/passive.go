@@ -0,0 +1,138 @@
+package xdcc
+
+import (
+	"animuxd/irc"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const passiveTimeoutMsec = 60000
+
+// Passive describes how to receive passive DCC SEND offers, i.e. ones where
+// the bot cannot listen and waits for us to listen instead.
+type Passive struct {
+	// ExternalIP is sent to bots as our address. Passive offers fail without it.
+	ExternalIP net.IP
+	// PortMin and PortMax bound ports we listen on. When zero, any free port is used.
+	PortMin int
+	PortMax int
+	// TimeoutMsec limits waiting for the bot to connect.
+	TimeoutMsec int64
+}
+
+func (p Passive) timeout() time.Duration {
+	if p.TimeoutMsec > 0 {
+		return time.Duration(p.TimeoutMsec) * time.Millisecond
+	}
+
+	return passiveTimeoutMsec * time.Millisecond
+}
+
+// listen opens a listener on the first free port of the range.
+func (p Passive) listen() (*net.TCPListener, error) {
+	if p.PortMin == 0 && p.PortMax == 0 {
+		return net.ListenTCP("tcp", &net.TCPAddr{})
+	}
+
+	// Port zero would bind any port outside of the range.
+	portMin := p.PortMin
+	if portMin < 1 {
+		portMin = 1
+	}
+
+	var err error
+	for port := portMin; port <= p.PortMax; port++ {
+		var listener *net.TCPListener
+		listener, err = net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+		if err == nil {
+			return listener, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No free port between %d and %d: %v", p.PortMin, p.PortMax, err)
+}
+
+// reservePassiveToken marks the token as being handled. Returns false when it already is,
+// e.g. because the bot repeated the offer.
+func (e *Engine) reservePassiveToken(token string) bool {
+	e.passiveTokensMutex.Lock()
+	defer e.passiveTokensMutex.Unlock()
+
+	if e.passiveTokens[token] {
+		return false
+	}
+	e.passiveTokens[token] = true
+
+	return true
+}
+
+func (e *Engine) releasePassiveToken(token string) {
+	e.passiveTokensMutex.Lock()
+	delete(e.passiveTokens, token)
+	e.passiveTokensMutex.Unlock()
+}
+
+// acceptPassive listens, sends our address to the bot along with the offer's token
+// and waits for the bot to connect.
+func (e *Engine) acceptPassive(ctx context.Context, n *network, botNick string, payload irc.PrivMsgDccSendPayload) (io.ReadCloser, error) {
+	if e.Passive.ExternalIP == nil {
+		return nil, errors.New("Passive DCC requires external IP")
+	}
+	if botNick == "" {
+		return nil, errors.New("Passive DCC offer without sender")
+	}
+
+	listener, err := e.Passive.listen()
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	listener.SetDeadline(time.Now().Add(e.Passive.timeout()))
+
+	accepted := make(chan bool)
+	defer close(accepted)
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-accepted:
+		}
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	n.ircEngine.SendMessage(botNick, fmt.Sprintf(
+		"\x01DCC SEND %s %s %d %d %s\x01",
+		dccFileName(payload.OfferedFileName()), dccAddress(e.Passive.ExternalIP), port, payload.FileLength, payload.Token,
+	))
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// dccAddress formats IPv4 as the classic 32-bit integer and IPv6 as a literal.
+func dccAddress(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprint(binary.BigEndian.Uint32(ip4))
+	}
+
+	return ip.String()
+}
+
+// dccFileName quotes names with spaces or quotes, escaping quotes and backslashes inside.
+func dccFileName(fileName string) string {
+	if strings.ContainsAny(fileName, " \"") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(fileName) + `"`
+	}
+
+	return fileName
+}
@@ -0,0 +1,116 @@
+package xdcc
+
+import (
+	"animuxd/irc"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// messagingIrcEngine passes sent messages over a channel.
+type messagingIrcEngine struct {
+	*fakeIrcEngine
+	sent chan string
+}
+
+func (e *messagingIrcEngine) SendMessage(nick string, body string) {
+	e.sent <- nick + " " + body
+}
+
+func startPassive(t *testing.T, passive Passive) (*Engine, *messagingIrcEngine, *FakeIOs) {
+	ircEngine := &messagingIrcEngine{fakeIrcEngine: &fakeIrcEngine{}, sent: make(chan string, 8)}
+	ircEngine.IRCPacketsChann()
+
+	dial, prepareWriter, fakeIOs := PrepareFakes()
+	engine := &Engine{Passive: passive}
+	engine.Start(dial, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo bar.mkv")
+	assert.Equal(t, "b0t XDCC SEND 42", <-ircEngine.sent)
+
+	return engine, ircEngine, fakeIOs
+}
+
+func downloadStatus(engine *Engine, fileName string) DownloadStatus {
+	engine.downloadsMutex.RLock()
+	defer engine.downloadsMutex.RUnlock()
+
+	return engine.Downloads[fileName].Status
+}
+
+func TestPassiveDccSend(t *testing.T) {
+	engine, ircEngine, fakeIOs := startPassive(t, Passive{ExternalIP: net.ParseIP("127.0.0.1"), TimeoutMsec: 1000})
+
+	offer := irc.Parse(":b0t!b@h PRIVMSG ownadi :\x01DCC SEND \"foo bar.mkv\" 2130706433 0 50 77\x01")
+	ircEngine.PacketsChan <- offer
+	ircEngine.PacketsChan <- offer
+
+	reply := <-ircEngine.sent
+	assert.True(t, strings.HasPrefix(reply, "b0t \x01DCC SEND \"foo bar.mkv\" 2130706433 "), reply)
+	payload := irc.Parse(":b0t PRIVMSG ownadi :" + strings.TrimPrefix(reply, "b0t ")).Payload.(irc.PrivMsgDccSendPayload)
+	assert.Equal(t, "77", payload.Token)
+	assert.Equal(t, int64(50), payload.FileLength)
+
+	conn, err := net.Dial("tcp", payload.Address())
+	if assert.Nil(t, err) {
+		conn.Write(make([]byte, 50))
+		conn.Close()
+	}
+
+	assert.Eventually(t, func() bool { return downloadStatus(engine, "foo bar.mkv") == Done }, time.Second, time.Millisecond)
+	assert.Equal(t, 50, fakeIOs.fw.BytesWritten)
+	select {
+	case message := <-ircEngine.sent:
+		t.Errorf("Repeated offer got answered: %q", message)
+	default:
+	}
+}
+
+func TestPassiveDccSendTimesOut(t *testing.T) {
+	engine, ircEngine, _ := startPassive(t, Passive{ExternalIP: net.ParseIP("127.0.0.1"), TimeoutMsec: 50})
+
+	ircEngine.PacketsChan <- irc.Parse(":b0t!b@h PRIVMSG ownadi :\x01DCC SEND \"foo bar.mkv\" 2130706433 0 50 77\x01")
+	<-ircEngine.sent
+
+	assert.Eventually(t, func() bool { return downloadStatus(engine, "foo bar.mkv") == Failed }, time.Second, time.Millisecond)
+}
+
+func TestPassiveDccSendWithoutExternalIP(t *testing.T) {
+	engine, ircEngine, _ := startPassive(t, Passive{})
+
+	ircEngine.PacketsChan <- irc.Parse(":b0t!b@h PRIVMSG ownadi :\x01DCC SEND \"foo bar.mkv\" 2130706433 0 50 77\x01")
+
+	assert.Eventually(t, func() bool { return downloadStatus(engine, "foo bar.mkv") == Failed }, time.Second, time.Millisecond)
+}
+
+func TestPassiveListenUsesPortRange(t *testing.T) {
+	taken, _ := net.ListenTCP("tcp", &net.TCPAddr{})
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	listener, err := Passive{PortMin: port, PortMax: port + 1}.listen()
+	if assert.Nil(t, err) {
+		assert.Equal(t, port+1, listener.Addr().(*net.TCPAddr).Port)
+		listener.Close()
+	}
+
+	_, err = Passive{PortMin: port, PortMax: port}.listen()
+	assert.NotNil(t, err)
+}
+
+func TestPassiveListenSkipsPortZero(t *testing.T) {
+	listener, err := Passive{PortMin: 0, PortMax: 1}.listen()
+	if err == nil {
+		assert.Equal(t, 1, listener.Addr().(*net.TCPAddr).Port)
+		listener.Close()
+	}
+}
+
+func TestDccAddress(t *testing.T) {
+	assert.Equal(t, "2130706433", dccAddress(net.ParseIP("127.0.0.1")))
+	assert.Equal(t, "2001:db8::1", dccAddress(net.ParseIP("2001:db8::1")))
+}
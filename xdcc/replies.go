@@ -0,0 +1,93 @@
+package xdcc
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BotReplyKind is a class of bot's answer to XDCC SEND.
+type BotReplyKind int
+
+const (
+	// InvalidPack means the bot does not have the requested package.
+	InvalidPack BotReplyKind = iota
+	// Denied means the bot refuses to send, e.g. because we are not on a known channel.
+	Denied
+	// AlreadyRequested means the package is already queued or being sent to us.
+	AlreadyRequested
+	// TransferLimit means we hit limit of parallel transfers from the bot.
+	TransferLimit
+	// Queued means the bot will send the package once a slot gets free.
+	Queued
+)
+
+func (k BotReplyKind) String() string {
+	switch k {
+	case InvalidPack:
+		return "invalid pack"
+	case Denied:
+		return "denied"
+	case AlreadyRequested:
+		return "already requested"
+	case TransferLimit:
+		return "transfer limit"
+	case Queued:
+		return "queued"
+	default:
+		return "unknown"
+	}
+}
+
+// Failed tells whether the bot will not send the package at all.
+func (k BotReplyKind) Failed() bool {
+	return k == InvalidPack || k == Denied
+}
+
+// BotReply is a classified NOTICE or PRIVMSG of a bot.
+type BotReply struct {
+	Kind BotReplyKind
+	// PackageNo is the package the reply refers to, 0 when the bot does not say.
+	PackageNo int
+	// Message is the bot's text stripped of formatting.
+	Message string
+}
+
+// botReplyPatterns are matched in order against lower-cased text, so more specific ones go first.
+// They cover iroffer, its forks and a few other popular bots.
+var botReplyPatterns = []struct {
+	kind    BotReplyKind
+	pattern *regexp.Regexp
+}{
+	{Queued, regexp.MustCompile(`added you to the (main |idle )?queue|you have been queued|queued for pack|in position \d+`)},
+	{AlreadyRequested, regexp.MustCompile(`already requested|already (in|have) (that|this) (pack|item)|already queued|already in (the )?queue`)},
+	{TransferLimit, regexp.MustCompile(`only have \d+ transfers? at a time|too many transfers|you already have \d+ (active )?transfers?|maximum of \d+ transfers?`)},
+	{InvalidPack, regexp.MustCompile(`invalid pack( number)?|pack( number)? out of range|no such pack|pack (number )?not found`)},
+	{Denied, regexp.MustCompile(`xdcc send denied|must be on a known channel|xdcc (is )?(disabled|restricted)|not allowed to (request|download)|you are banned|access denied`)},
+}
+
+var botReplyPackPattern = regexp.MustCompile(`pack\s*#?(\d+)`)
+
+// formattingPattern matches mIRC color, bold, italics, underline, reverse and reset codes.
+var formattingPattern = regexp.MustCompile("\x03(\\d{1,2}(,\\d{1,2})?)?|[\x02\x0f\x16\x1d\x1e\x1f]")
+
+// ClassifyBotReply recognizes common bot answers to XDCC SEND.
+// Returns false for anything else, e.g. adverts or greetings.
+func ClassifyBotReply(text string) (BotReply, bool) {
+	message := strings.TrimSpace(formattingPattern.ReplaceAllString(text, ""))
+	lower := strings.ToLower(message)
+
+	for _, p := range botReplyPatterns {
+		if !p.pattern.MatchString(lower) {
+			continue
+		}
+
+		reply := BotReply{Kind: p.kind, Message: message}
+		if captures := botReplyPackPattern.FindStringSubmatch(lower); captures != nil {
+			reply.PackageNo, _ = strconv.Atoi(captures[1])
+		}
+		return reply, true
+	}
+
+	return BotReply{}, false
+}
@@ -0,0 +1,42 @@
+package xdcc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyBotReply(t *testing.T) {
+	tests := []struct {
+		text      string
+		kind      BotReplyKind
+		packageNo int
+	}{
+		{"** Invalid Pack Number, Try Again", InvalidPack, 0},
+		{"\x02**\x02 Invalid Pack Number, Try Again", InvalidPack, 0},
+		{"** You already requested that pack", AlreadyRequested, 0},
+		{"** You can only have 1 transfer at a time", TransferLimit, 0},
+		{"** You can only have 1 transfer at a time, Added you to the main queue for pack 42 (\"foo.mkv\") in position 3. To Remove yourself at a later time type \"/MSG b0t XDCC REMOVE 42\".", Queued, 42},
+		{"** All Slots Full, Added you to the main queue for pack 7 (\"foo.mkv\") in position 1.", Queued, 7},
+		{"\x0304XDCC SEND denied, you must be on a known channel to request a pack", Denied, 0},
+		{"You are already in that pack's queue (pack #12)", AlreadyRequested, 12},
+	}
+
+	for _, test := range tests {
+		reply, ok := ClassifyBotReply(test.text)
+
+		assert.True(t, ok, test.text)
+		assert.Equal(t, test.kind, reply.Kind, test.text)
+		assert.Equal(t, test.packageNo, reply.PackageNo, test.text)
+		assert.NotContains(t, reply.Message, "\x02", test.text)
+		assert.NotContains(t, reply.Message, "\x03", test.text)
+	}
+}
+
+func TestClassifyBotReplyIgnoresOtherText(t *testing.T) {
+	for _, text := range []string{"", "Hello!", "** Sending you pack #42 (\"foo.mkv\"), which is 1.2GB. (resume supported)", "Total Offered: 1.2 TB"} {
+		_, ok := ClassifyBotReply(text)
+
+		assert.False(t, ok, text)
+	}
+}
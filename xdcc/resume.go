@@ -0,0 +1,75 @@
+package xdcc
+
+import (
+	"animuxd/irc"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// acceptKey identifies DCC ACCEPT answering our DCC RESUME.
+// Bots mangle file names in it, hence only the port and the passive token are used.
+func acceptKey(networkName string, port uint64, token string) string {
+	return fmt.Sprintf("%s %d %s", networkName, port, token)
+}
+
+// negotiateResume asks the bot to continue the transfer from the already stored part of the file.
+// Returns the position accepted by the bot or 0, when there is nothing to resume
+// or the bot does not answer in time.
+func (e *Engine) negotiateResume(ctx context.Context, networkName string, n *network, botNick string, payload irc.PrivMsgDccSendPayload) int64 {
+	if e.Resumer == nil || botNick == "" {
+		return 0
+	}
+	position := e.Resumer.Position(e, payload)
+	if position <= 0 || position >= payload.FileLength {
+		return 0
+	}
+
+	key := acceptKey(networkName, payload.Port, payload.Token)
+	accepted := make(chan int64, 1)
+	e.acceptsMutex.Lock()
+	e.accepts[key] = accepted
+	e.acceptsMutex.Unlock()
+	defer func() {
+		e.acceptsMutex.Lock()
+		delete(e.accepts, key)
+		e.acceptsMutex.Unlock()
+	}()
+
+	resume := fmt.Sprintf("DCC RESUME %s %d %d", dccFileName(payload.OfferedFileName()), payload.Port, position)
+	if payload.Token != "" {
+		resume += " " + payload.Token
+	}
+	n.ircEngine.SendMessage(botNick, "\x01"+resume+"\x01")
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, e.timeout())
+	defer cancel()
+
+	select {
+	case acceptedPosition := <-accepted:
+		if acceptedPosition > position {
+			return 0
+		}
+		return acceptedPosition
+	case <-timeoutCtx.Done():
+		return 0
+	}
+}
+
+// handleDccAccept passes position accepted by the bot to the pending resume, if any.
+func (e *Engine) handleDccAccept(networkName string, packet irc.Packet) {
+	payload, payloadOk := packet.Payload.(irc.PrivMsgDccAcceptPayload)
+	if !payloadOk {
+		return
+	}
+
+	e.acceptsMutex.Lock()
+	defer e.acceptsMutex.Unlock()
+
+	if accepted, ok := e.accepts[acceptKey(networkName, payload.Port, strings.TrimSpace(payload.Token))]; ok {
+		select {
+		case accepted <- payload.Position:
+		default:
+		}
+	}
+}
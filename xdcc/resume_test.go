@@ -0,0 +1,127 @@
+package xdcc
+
+import (
+	"animuxd/irc"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResumer pretends position bytes of every file are stored.
+type fakeResumer struct {
+	position int64
+	writer   *FakeWriter
+}
+
+func (r *fakeResumer) Position(engine *Engine, payload irc.PrivMsgDccSendPayload) int64 {
+	return r.position
+}
+
+func (r *fakeResumer) OpenAppend(engine *Engine, payload irc.PrivMsgDccSendPayload, position int64) (io.Writer, io.Closer, error) {
+	return r.writer, r.writer, nil
+}
+
+// serveBytes accepts a single connection and sends n bytes over it.
+func serveBytes(listener net.Listener, n int) {
+	conn, err := listener.Accept()
+	if err == nil {
+		conn.Write(make([]byte, n))
+		conn.Close()
+	}
+}
+
+func startResumable(t *testing.T, resumer Resumer) (*Engine, *messagingIrcEngine, *FakeIOs) {
+	ircEngine := &messagingIrcEngine{fakeIrcEngine: &fakeIrcEngine{}, sent: make(chan string, 8)}
+	ircEngine.IRCPacketsChann()
+
+	_, prepareWriter, fakeIOs := PrepareFakes()
+	engine := &Engine{Resumer: resumer, TimeoutMsec: 200}
+	engine.Start(DialTCP, prepareWriter, false)
+	engine.AddNetwork("foo", ircEngine)
+
+	<-engine.RequestFile("foo", "b0t", 42, "foo.bar")
+	assert.Equal(t, "b0t XDCC SEND 42", <-ircEngine.sent)
+
+	return engine, ircEngine, fakeIOs
+}
+
+func TestResume(t *testing.T) {
+	resumer := &fakeResumer{position: 20, writer: &FakeWriter{}}
+	engine, ircEngine, _ := startResumable(t, resumer)
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go serveBytes(listener, 30)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	ircEngine.PacketsChan <- irc.Parse(fmt.Sprintf(":b0t!b@h PRIVMSG ownadi :\x01DCC SEND foo.bar 2130706433 %d 50\x01", port))
+	assert.Equal(t, fmt.Sprintf("b0t \x01DCC RESUME foo.bar %d 20\x01", port), <-ircEngine.sent)
+	ircEngine.PacketsChan <- irc.Parse(fmt.Sprintf(":b0t!b@h PRIVMSG ownadi :\x01DCC ACCEPT file.ext %d 20\x01", port))
+
+	assert.Eventually(t, func() bool { return downloadStatus(engine, "foo.bar") == Done }, time.Second, time.Millisecond)
+	assert.Equal(t, 30, resumer.writer.BytesWritten)
+	engine.downloadsMutex.RLock()
+	assert.Equal(t, uint64(50), engine.Downloads["foo.bar"].Downloaded)
+	engine.downloadsMutex.RUnlock()
+}
+
+func TestResumeRepliesWithOfferedFileName(t *testing.T) {
+	resumer := &fakeResumer{position: 20, writer: &FakeWriter{}}
+	engine, ircEngine, _ := startResumable(t, resumer)
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go serveBytes(listener, 30)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	offer := irc.Parse(fmt.Sprintf(":b0t!b@h PRIVMSG ownadi :\x01DCC SEND foo.bar 2130706433 %d 50\x01", port))
+	payload := offer.Payload.(irc.PrivMsgDccSendPayload)
+	payload.RawFileName = "fo\xf6.bar"
+	offer.Payload = payload
+	ircEngine.PacketsChan <- offer
+	assert.Equal(t, fmt.Sprintf("b0t \x01DCC RESUME fo\xf6.bar %d 20\x01", port), <-ircEngine.sent)
+	ircEngine.PacketsChan <- irc.Parse(fmt.Sprintf(":b0t!b@h PRIVMSG ownadi :\x01DCC ACCEPT file.ext %d 20\x01", port))
+
+	assert.Eventually(t, func() bool { return downloadStatus(engine, "foo.bar") == Done }, time.Second, time.Millisecond)
+}
+
+func TestResumeWithoutAccept(t *testing.T) {
+	resumer := &fakeResumer{position: 20, writer: &FakeWriter{}}
+	engine, ircEngine, fakeIOs := startResumable(t, resumer)
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go serveBytes(listener, 50)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	ircEngine.PacketsChan <- irc.Parse(fmt.Sprintf(":b0t!b@h PRIVMSG ownadi :\x01DCC SEND foo.bar 2130706433 %d 50\x01", port))
+	<-ircEngine.sent
+
+	assert.Eventually(t, func() bool { return downloadStatus(engine, "foo.bar") == Done }, time.Second, time.Millisecond)
+	assert.Equal(t, 0, resumer.writer.BytesWritten)
+	assert.Equal(t, 50, fakeIOs.fw.BytesWritten)
+}
+
+func TestResumeSkipsCompleteFiles(t *testing.T) {
+	resumer := &fakeResumer{position: 50, writer: &FakeWriter{}}
+	engine, ircEngine, fakeIOs := startResumable(t, resumer)
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go serveBytes(listener, 50)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	ircEngine.PacketsChan <- irc.Parse(fmt.Sprintf(":b0t!b@h PRIVMSG ownadi :\x01DCC SEND foo.bar 2130706433 %d 50\x01", port))
+
+	assert.Eventually(t, func() bool { return downloadStatus(engine, "foo.bar") == Done }, time.Second, time.Millisecond)
+	assert.Equal(t, 50, fakeIOs.fw.BytesWritten)
+	select {
+	case message := <-ircEngine.sent:
+		t.Errorf("Unexpected message: %q", message)
+	default:
+	}
+}